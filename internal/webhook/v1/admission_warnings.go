@@ -0,0 +1,52 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type admissionWarningsKey struct{}
+
+// warningHandler wraps the defaulter webhook handler, and adds the warnings collected by the
+// defaulter to the response, which webhook.CustomDefaulter can't return itself.
+type warningHandler struct {
+	admission.Handler
+}
+
+func (h *warningHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	warnings := &admission.Warnings{}
+	resp := h.Handler.Handle(context.WithValue(ctx, admissionWarningsKey{}, warnings), req)
+	resp.Warnings = append(resp.Warnings, *warnings...)
+	return resp
+}
+
+// addAdmissionWarnings records warnings for the admission request of ctx. They are dropped
+// when ctx doesn't belong to an admission request handled by warningHandler.
+func addAdmissionWarnings(ctx context.Context, warnings ...string) {
+	if len(warnings) == 0 {
+		return
+	}
+	collected, ok := ctx.Value(admissionWarningsKey{}).(*admission.Warnings)
+	if !ok {
+		podlog.Info("drop admission warnings outside of an admission request", "warnings", warnings)
+		return
+	}
+	*collected = append(*collected, warnings...)
+}
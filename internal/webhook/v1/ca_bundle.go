@@ -0,0 +1,50 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ensureCABundle copies the configured CA bundle source into the pod namespace, so the bundle
+// volume can be mounted there. Dry-run requests are skipped since they must not have side effects.
+func (d *PodCustomDefaulter) ensureCABundle(ctx context.Context, pod *corev1.Pod, config *injector.InjectConf) error {
+	bundle := config.CABundle
+	nsName := pod.GetNamespace()
+	if bundle == nil || bundle.Namespace == "" || bundle.Namespace == nsName {
+		return nil
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.DryRun != nil && *req.DryRun {
+		podlog.Info("skip copying ca bundle for dry-run request", "pod", pod.Name, "name", bundle.Name)
+		return nil
+	}
+
+	var source client.Object = &corev1.ConfigMap{}
+	if bundle.Kind == injector.CABundleKindSecret {
+		source = &corev1.Secret{}
+	}
+	if err := d.kubeClient.Get(ctx, client.ObjectKey{Namespace: bundle.Namespace, Name: bundle.Name}, source); err != nil {
+		return fmt.Errorf("failed to get ca bundle source %s/%s: %w", bundle.Namespace, bundle.Name, err)
+	}
+	return d.copyIntoNamespace(ctx, source, nsName)
+}
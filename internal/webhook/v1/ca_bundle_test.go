@@ -0,0 +1,87 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("CA bundle copy", func() {
+	var (
+		ctx        context.Context
+		defaulter  *PodCustomDefaulter
+		fakeClient client.Client
+		config     *injector.InjectConf
+		pod        *corev1.Pod
+		source     *corev1.ConfigMap
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = injector.NewDefaultInjectConf()
+		config.CABundle = &injector.CABundle{Namespace: "dragonfly-system", Name: "dfdaemon-ca"}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "app"}}
+		source = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "dfdaemon-ca", Namespace: "dragonfly-system"},
+			Data:       map[string]string{injector.DefaultCABundleKey: "-----BEGIN CERTIFICATE-----"},
+		}
+	})
+
+	It("should copy the source configmap into the pod namespace", func() {
+		defaulter, fakeClient = newFakeDefaulter(source)
+		Expect(defaulter.ensureCABundle(ctx, pod, config)).To(Succeed())
+
+		target := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "dfdaemon-ca"}, target)).To(Succeed())
+		Expect(target.Data).To(Equal(source.Data))
+		Expect(target.Labels).To(HaveKeyWithValue(ManagedByLabelName, ManagedByLabelValue))
+	})
+
+	It("should copy secret sources as secrets", func() {
+		config.CABundle.Kind = injector.CABundleKindSecret
+		secret := &corev1.Secret{
+			ObjectMeta: source.ObjectMeta,
+			Data:       map[string][]byte{injector.DefaultCABundleKey: []byte("-----BEGIN CERTIFICATE-----")},
+		}
+		defaulter, fakeClient = newFakeDefaulter(secret)
+		Expect(defaulter.ensureCABundle(ctx, pod, config)).To(Succeed())
+
+		target := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "dfdaemon-ca"}, target)).To(Succeed())
+		Expect(target.Data).To(Equal(secret.Data))
+	})
+
+	It("should not copy a source in the pod namespace", func() {
+		config.CABundle.Namespace = "app"
+		defaulter, fakeClient = newFakeDefaulter()
+		Expect(defaulter.ensureCABundle(ctx, pod, config)).To(Succeed())
+	})
+
+	It("should fail when the source doesn't exist", func() {
+		defaulter, fakeClient = newFakeDefaulter()
+		err := defaulter.ensureCABundle(ctx, pod, config)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
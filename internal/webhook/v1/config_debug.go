@@ -0,0 +1,163 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// ConfigDebugPath is served by the manager's metrics server, so it shares its authn/authz.
+	ConfigDebugPath string = "/debug/config"
+
+	// maxDebugPodManifestSize limits the size of pod manifests posted to the debug endpoint.
+	maxDebugPodManifestSize int64 = 1 << 20
+)
+
+// ConfigDebugHandler is a read-only HTTP handler exposing the loaded config and its provenance.
+//
+// GET returns the loaded config. POST takes a pod manifest (JSON or YAML) and an optional
+// namespace query parameter, and additionally returns the effective config for that pod.
+type ConfigDebugHandler struct {
+	defaulter *PodCustomDefaulter
+}
+
+type configDebugResponse struct {
+	ActiveAPIVersion string `json:"activeAPIVersion"`
+	*injector.ConfigSnapshot
+	Pod *podDebugResponse `json:"pod,omitempty"`
+}
+
+type podDebugResponse struct {
+	Namespace         string `json:"namespace"`
+	Name              string `json:"name,omitempty"`
+	InjectionRequired bool   `json:"injectionRequired"`
+	// HostNetworkSkipped is set if the pod is not injected because it uses the network of the node
+	HostNetworkSkipped bool                 `json:"hostNetworkSkipped,omitempty"`
+	EffectiveConfig    *injector.InjectConf `json:"effectiveConfig"`
+	// DfdaemonHost is the host the pod reaches the dfdaemon at
+	DfdaemonHost string `json:"dfdaemonHost,omitempty"`
+	// CliToolsImage is the injected cli tools image, after the version pinning
+	CliToolsImage string `json:"cliToolsImage,omitempty"`
+	// Warnings are the admission warnings of the pod
+	Warnings []string `json:"warnings,omitempty"`
+	// Error is the reason the pod is rejected
+	Error string `json:"error,omitempty"`
+}
+
+func NewConfigDebugHandler(defaulter *PodCustomDefaulter) *ConfigDebugHandler {
+	return &ConfigDebugHandler{defaulter: defaulter}
+}
+
+func (h *ConfigDebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := &configDebugResponse{
+		ActiveAPIVersion: injector.ConfigAPIVersion,
+		ConfigSnapshot:   h.defaulter.configManager.Snapshot(),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		pod, err := decodeDebugPod(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Pod = h.debugPod(r.Context(), pod, resp.Config)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		podlog.Error(err, "failed to write config debug response")
+	}
+}
+
+// debugPod resolves the config the pod would be injected with, like the defaulter does before mutating it.
+func (h *ConfigDebugHandler) debugPod(ctx context.Context, pod *corev1.Pod, config *injector.InjectConf) *podDebugResponse {
+	d := h.defaulter
+	effective, podAdmission := d.effectiveConfig(ctx, config, pod)
+	resp := &podDebugResponse{
+		Namespace:          pod.Namespace,
+		Name:               pod.Name,
+		InjectionRequired:  d.injectRequired(ctx, pod),
+		HostNetworkSkipped: injector.SkipHostNetworkPod(config, pod),
+		EffectiveConfig:    effective,
+	}
+	if !resp.InjectionRequired || resp.HostNetworkSkipped {
+		return resp
+	}
+
+	for _, ij := range d.injectors {
+		admitter, ok := ij.(Admitter)
+		if !ok {
+			continue
+		}
+		warnings, err := admitter.Admit(pod, effective, podAdmission)
+		resp.Warnings = append(resp.Warnings, warnings...)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+	}
+
+	effective, warning := injector.CheckDfdaemonCompatibility(effective, podAdmission, pod, d.dfdaemonVersion(ctx, effective))
+	if warning != "" {
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+	warning, err := d.digestResolver.PinCliToolsImage(ctx, effective, podAdmission, pod, d.cliToolsPullSecrets(pod, effective))
+	if warning != "" {
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.DfdaemonHost = injector.DfdaemonHost(effective, podAdmission)
+	if !effective.DisableCliTools {
+		resp.CliToolsImage = injector.CliToolsImageForPod(effective, podAdmission, pod)
+	}
+	return resp
+}
+
+// decodeDebugPod decodes the posted pod manifest, the namespace query parameter takes precedence
+// over the manifest's namespace.
+func decodeDebugPod(r *http.Request) (*corev1.Pod, error) {
+	pod := &corev1.Pod{}
+	body := io.LimitReader(r.Body, maxDebugPodManifestSize)
+	if err := yaml.NewYAMLOrJSONDecoder(body, 4096).Decode(pod); err != nil {
+		return nil, fmt.Errorf("failed to decode pod manifest: %w", err)
+	}
+	if ns := r.URL.Query().Get("namespace"); ns != "" {
+		pod.Namespace = ns
+	}
+	if pod.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required, set it in the pod manifest or the namespace query parameter")
+	}
+	return pod, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Config debug handler", func() {
+	var (
+		handler    *ConfigDebugHandler
+		configPath string
+	)
+
+	newHandler := func(config string) {
+		tempDir := GinkgoT().TempDir()
+		configPath = filepath.Join(tempDir, "config.yaml")
+		Expect(os.WriteFile(configPath, []byte(config), 0644)).To(Succeed())
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		labeledNs := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "labeled",
+				Labels: map[string]string{
+					injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue,
+				},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(labeledNs).Build()
+		handler = NewConfigDebugHandler(NewPodCustomDefaulter(fakeClient, injector.NewConfigManager(tempDir)))
+	}
+
+	BeforeEach(func() {
+		newHandler("apiVersion: webhook.d7y.io/v1alpha2\nenable: true\nproxyPort: 8001\n")
+	})
+
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec, nil
+		}
+		body := map[string]any{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+		return rec, body
+	}
+
+	It("should return the loaded config and its provenance", func() {
+		By("requesting the loaded config")
+		rec, body := serve(httptest.NewRequest(http.MethodGet, ConfigDebugPath, nil))
+
+		By("verifying the response")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(body).To(HaveKeyWithValue("activeAPIVersion", injector.ConfigAPIVersion))
+		Expect(body).To(HaveKeyWithValue("apiVersion", injector.ConfigAPIVersionV1Alpha2))
+		Expect(body).To(HaveKeyWithValue("source", configPath))
+		Expect(body).To(HaveKeyWithValue("contentHash", HavePrefix("sha256:")))
+		Expect(body).To(HaveKey("loadedAt"))
+		Expect(body).NotTo(HaveKey("lastReloadError"))
+		Expect(body).To(HaveKeyWithValue("config", HaveKeyWithValue("proxyPort", BeNumerically("==", 8001))))
+		Expect(body).NotTo(HaveKey("pod"))
+	})
+
+	It("should return the effective config for a posted pod", func() {
+		By("posting a pod manifest with an annotation override")
+		manifest := `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: test-pod
+  annotations:
+    dragonfly.io/cli-tools-image: annotated/tools:v1
+`
+		req := httptest.NewRequest(http.MethodPost, ConfigDebugPath+"?namespace=labeled", strings.NewReader(manifest))
+		rec, body := serve(req)
+
+		By("verifying the merged config")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(body).To(HaveKeyWithValue("config", HaveKeyWithValue("cliToolsImage", "")))
+		Expect(body).To(HaveKeyWithValue("pod", And(
+			HaveKeyWithValue("namespace", "labeled"),
+			HaveKeyWithValue("name", "test-pod"),
+			HaveKeyWithValue("injectionRequired", true),
+			HaveKeyWithValue("effectiveConfig", And(
+				HaveKeyWithValue("cliToolsImage", "annotated/tools:v1"),
+				HaveKeyWithValue("proxyPort", BeNumerically("==", 8001)),
+			)),
+			HaveKeyWithValue("dfdaemonHost", "$("+injector.NodeNameEnvName+")"),
+			HaveKeyWithValue("cliToolsImage", "annotated/tools:v1"),
+		)))
+	})
+
+	It("should return the proxy host and skipping of hostNetwork pods", func() {
+		manifest := `{"metadata":{"name":"test-pod"},"spec":{"hostNetwork":true}}`
+
+		By("posting a hostNetwork pod with a hostNetwork profile")
+		newHandler("apiVersion: webhook.d7y.io/v1alpha2\nenable: true\nhostNetwork:\n  disableCliTools: true\n")
+		req := httptest.NewRequest(http.MethodPost, ConfigDebugPath+"?namespace=labeled", strings.NewReader(manifest))
+		rec, body := serve(req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(body).To(HaveKeyWithValue("pod", And(
+			HaveKeyWithValue("injectionRequired", true),
+			Not(HaveKey("hostNetworkSkipped")),
+			HaveKeyWithValue("dfdaemonHost", injector.DefaultHostNetworkProxyHost),
+			Not(HaveKey("cliToolsImage")),
+		)))
+
+		By("posting a hostNetwork pod with hostNetwork pods skipped")
+		newHandler("apiVersion: webhook.d7y.io/v1alpha2\nenable: true\nhostNetwork:\n  skip: true\n")
+		req = httptest.NewRequest(http.MethodPost, ConfigDebugPath+"?namespace=labeled", strings.NewReader(manifest))
+		rec, body = serve(req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(body).To(HaveKeyWithValue("pod", And(
+			HaveKeyWithValue("hostNetworkSkipped", true),
+			Not(HaveKey("dfdaemonHost")),
+		)))
+	})
+
+	It("should reject a pod without namespace", func() {
+		req := httptest.NewRequest(http.MethodPost, ConfigDebugPath, strings.NewReader(`{"metadata":{"name":"p"}}`))
+		rec, _ := serve(req)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject other methods", func() {
+		rec, _ := serve(httptest.NewRequest(http.MethodDelete, ConfigDebugPath, nil))
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
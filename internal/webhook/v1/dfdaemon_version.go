@@ -0,0 +1,41 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dfdaemonVersion returns the version of the dfdaemon DaemonSet, read through the manager cache so
+// admission requests don't call the API server. It returns an empty string if the version is unknown.
+func (d *PodCustomDefaulter) dfdaemonVersion(ctx context.Context, config *injector.InjectConf) string {
+	compat := config.DfdaemonCompatibility
+	if compat == nil {
+		return ""
+	}
+	ds := &appsv1.DaemonSet{}
+	key := client.ObjectKey{Namespace: compat.DaemonSetNamespace, Name: compat.DaemonSetName}
+	if err := d.kubeClient.Get(ctx, key, ds); err != nil {
+		podlog.Error(err, "failed to get dfdaemon daemonset", "daemonset", key)
+		return ""
+	}
+	return injector.DfdaemonVersion(ds, compat)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// hostIPFamilyTTL is how long the host IP family of the cluster is cached, so the nodes are not listed
+// on every admission while a change of the cluster is still noticed without a restart.
+const hostIPFamilyTTL = 10 * time.Minute
+
+// hostIPFamilyCache caches the family of status.hostIP of the cluster for admission requests.
+type hostIPFamilyCache struct {
+	client client.Reader
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	family    corev1.IPFamily
+	fetchedAt time.Time
+}
+
+func newHostIPFamilyCache(client client.Reader) *hostIPFamilyCache {
+	return &hostIPFamilyCache{client: client, ttl: hostIPFamilyTTL}
+}
+
+// Get returns the cached family, from the first node reporting an IP, listing the nodes when it expired.
+// A failed list is logged and returns the previous family, which is empty if the nodes were never listed.
+func (c *hostIPFamilyCache) Get(ctx context.Context) corev1.IPFamily {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.ttl {
+		return c.family
+	}
+	nodes := &corev1.NodeList{}
+	if err := c.client.List(ctx, nodes); err != nil {
+		podlog.Error(err, "failed to list nodes")
+		return c.family
+	}
+	c.family, c.fetchedAt = "", time.Now()
+	for i := range nodes.Items {
+		if family := injector.NodeHostIPFamily(&nodes.Items[i]); family != "" {
+			c.family = family
+			break
+		}
+	}
+	return c.family
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("hostIPFamilyCache", func() {
+	var (
+		ctx   context.Context
+		lists int
+		c     client.Client
+	)
+
+	node := func(name, address string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		lists = 0
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(node("node-1", "fd00::1")).
+			WithInterceptorFuncs(interceptor.Funcs{
+				List: func(ctx context.Context, client client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+					lists++
+					return client.List(ctx, list, opts...)
+				},
+			}).Build()
+	})
+
+	It("should cache the family of the cluster until it expires", func() {
+		cache := newHostIPFamilyCache(c)
+		Expect(cache.Get(ctx)).To(Equal(corev1.IPv6Protocol))
+		Expect(cache.Get(ctx)).To(Equal(corev1.IPv6Protocol))
+		Expect(lists).To(Equal(1))
+
+		By("listing the nodes again after the TTL")
+		Expect(c.Delete(ctx, node("node-1", ""))).To(Succeed())
+		Expect(c.Create(ctx, node("node-2", "10.0.0.1"))).To(Succeed())
+		cache.fetchedAt = time.Now().Add(-hostIPFamilyTTL)
+		Expect(cache.Get(ctx)).To(Equal(corev1.IPv4Protocol))
+		Expect(lists).To(Equal(2))
+	})
+})
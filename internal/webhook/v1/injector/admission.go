@@ -0,0 +1,38 @@
+package injector
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodAdmission is the state of the admission of one pod. The config is shared by the admissions of many
+// pods, so what depends on the pod alone, and what the injectors find while injecting it, is kept here
+// for the duration of the request.
+type PodAdmission struct {
+	// proxyHost is the URL host of the dfdaemon replacing the configured one, the node IP of pods bound
+	// to a node at creation or the host of hostNetwork pods, see proxyHostIPForPod and HostNetworkProfile.
+	proxyHost string
+	// hostIPFamily is the family of status.hostIP of the cluster, which the env vars of the pod reference,
+	// ProxyIPFamily when unset, see hostIPFamilyForPod.
+	hostIPFamily corev1.IPFamily
+	// cliToolsImage is the cli tools image of the pod chosen for the dfdaemon version, or pinned to
+	// its digest, see CheckDfdaemonCompatibility and DigestResolver.
+	cliToolsImage string
+	// conflicts are the items of the pod differing from the injected ones, see CheckConflicts
+	conflicts []Conflict
+}
+
+// NewPodAdmission starts the admission of the pod with its effective config. nsInfo may be nil.
+func NewPodAdmission(config *InjectConf, pod *corev1.Pod, nsInfo *NamespaceInfo) *PodAdmission {
+	admission := &PodAdmission{hostIPFamily: hostIPFamilyForPod(config, nsInfo)}
+	if pod.Spec.HostNetwork && config.HostNetwork != nil {
+		admission.proxyHost = config.HostNetwork.proxyHost()
+	} else if ip := proxyHostIPForPod(config, pod, nsInfo); ip.IsValid() {
+		admission.proxyHost = urlHost(ip)
+	}
+	return admission
+}
+
+// overridesDfdaemonHost reports whether the host of the dfdaemon differs from the one of the config.
+func (a *PodAdmission) overridesDfdaemonHost() bool {
+	return a != nil && (a.proxyHost != "" || a.hostIPFamily != "")
+}
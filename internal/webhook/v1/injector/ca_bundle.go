@@ -0,0 +1,178 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Kinds of CA bundle sources
+	CABundleKindConfigMap string = "ConfigMap"
+	CABundleKindSecret    string = "Secret"
+
+	// Modes of CA bundle injection
+	CABundleModeMerge   string = "Merge"   // the env vars point to the bundle appended to the system CAs of CABundle.Image
+	CABundleModeReplace string = "Replace" // the env vars point to the bundle alone, public CAs are no longer trusted
+
+	DefaultCABundleKey           string = "ca.crt"
+	DefaultCABundleSystemCAsFile string = "/etc/ssl/certs/ca-certificates.crt" // System CAs of Debian based images
+)
+
+// DefaultCABundleEnvNames are the env vars pointing the usual TLS clients to the CA bundle.
+var DefaultCABundleEnvNames = []string{
+	"SSL_CERT_FILE",       // OpenSSL, Go, Ruby
+	"REQUESTS_CA_BUNDLE",  // Python requests
+	"CURL_CA_BUNDLE",      // curl
+	"NODE_EXTRA_CA_CERTS", // Node.js, added to its bundled CAs
+	"GIT_SSL_CAINFO",      // git
+}
+
+// CABundle is the CA bundle of the dfdaemon proxy, so containers trust the certificates it issues
+// when it intercepts HTTPS.
+type CABundle struct {
+	// Kind of the source holding the bundle, CABundleKindConfigMap when unset
+	Kind string `yaml:"kind,omitempty" json:"kind,omitempty"`
+	// Namespace of the source, which is copied into the pod namespace, the pod namespace when unset
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	Name      string `yaml:"name" json:"name"`
+	// Key of the bundle in the source, DefaultCABundleKey when unset
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+	// CABundleModeMerge when unset, which appends the bundle to the system CAs of Image with an initContainer.
+	// With CABundleModeReplace, TLS to hosts the proxy doesn't intercept fails unless they use the bundle CAs
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Image of the CABundleModeMerge initContainer, which runs sh and cat and provides the system CAs, the cli
+	// tools image when unset. Required with CliToolsInstallModeEntrypoint, whose images may have no shell
+	Image string `yaml:"image,omitempty" json:"image,omitempty"`
+	// System CAs file of Image, DefaultCABundleSystemCAsFile when unset
+	SystemCAsFile string `yaml:"systemCAsFile,omitempty" json:"systemCAsFile,omitempty"`
+	// Env vars set to the bundle path, DefaultCABundleEnvNames when unset
+	EnvNames []string `yaml:"envNames,omitempty" json:"envNames,omitempty"`
+}
+
+func (b *CABundle) validate() error {
+	if b.Name == "" {
+		return errors.New("ca bundle requires a name")
+	}
+	switch b.Kind {
+	case "", CABundleKindConfigMap, CABundleKindSecret:
+	default:
+		return fmt.Errorf("invalid ca bundle kind %q", b.Kind)
+	}
+	switch b.Mode {
+	case "", CABundleModeReplace, CABundleModeMerge:
+	default:
+		return fmt.Errorf("invalid ca bundle mode %q", b.Mode)
+	}
+	if b.SystemCAsFile != "" && !path.IsAbs(b.SystemCAsFile) {
+		return fmt.Errorf("ca bundle system CAs file %q must be an absolute path", b.SystemCAsFile)
+	}
+	return nil
+}
+
+func (b *CABundle) key() string {
+	if b.Key != "" {
+		return b.Key
+	}
+	return DefaultCABundleKey
+}
+
+func (b *CABundle) mode() string {
+	if b.Mode != "" {
+		return b.Mode
+	}
+	return CABundleModeMerge
+}
+
+func (b *CABundle) systemCAsFile() string {
+	if b.SystemCAsFile != "" {
+		return b.SystemCAsFile
+	}
+	return DefaultCABundleSystemCAsFile
+}
+
+func (b *CABundle) envNames() []string {
+	if len(b.EnvNames) != 0 {
+		return b.EnvNames
+	}
+	return DefaultCABundleEnvNames
+}
+
+// volumeSource returns the volume projecting the bundle of the source to DefaultCABundleKey.
+func (b *CABundle) volumeSource() corev1.VolumeSource {
+	items := []corev1.KeyToPath{{Key: b.key(), Path: DefaultCABundleKey}}
+	if b.Kind == CABundleKindSecret {
+		return corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: b.Name, Items: items}}
+	}
+	return corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+		LocalObjectReference: corev1.LocalObjectReference{Name: b.Name},
+		Items:                items,
+	}}
+}
+
+type CABundleInjector struct{}
+
+func NewCABundleInjector() *CABundleInjector {
+	return &CABundleInjector{}
+}
+
+func (cbi *CABundleInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
+	bundle := config.CABundle
+	if bundle == nil {
+		return
+	}
+	podlog.Info("CABundleInjector Inject")
+
+	if bundle.mode() == CABundleModeMerge {
+		// the source is only mounted by the initContainer, which writes the merged bundle
+		addVolume(config, admission, pod, corev1.Volume{Name: CABundleSourceVolumeName, VolumeSource: bundle.volumeSource()})
+		addVolume(config, admission, pod, corev1.Volume{
+			Name:         CABundleVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		addInitContainer(config, admission, pod, cbi.mergeContainer(pod, config, admission))
+	} else {
+		addVolume(config, admission, pod, corev1.Volume{Name: CABundleVolumeName, VolumeSource: bundle.volumeSource()})
+	}
+
+	bundleFile := path.Join(CABundleDirPath, DefaultCABundleKey)
+	envs := make([]corev1.EnvVar, 0, len(bundle.envNames()))
+	for _, name := range bundle.envNames() {
+		envs = append(envs, corev1.EnvVar{Name: name, Value: bundleFile})
+	}
+	for i := range pod.Spec.Containers {
+		addVolumeMount(config, admission, &pod.Spec.Containers[i], corev1.VolumeMount{
+			Name:      CABundleVolumeName,
+			MountPath: CABundleDirPath,
+			ReadOnly:  true,
+		})
+		injectContainer(config, admission, &pod.Spec.Containers[i], envs)
+	}
+}
+
+// mergeContainer returns the initContainer appending the bundle to the system CAs of the merge image.
+func (cbi *CABundleInjector) mergeContainer(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) corev1.Container {
+	sourceDir := CABundleDirPath + "-source"
+	image := CliToolsImageForPod(config, admission, pod)
+	if config.CABundle.Image != "" {
+		image = config.rewriteImage(config.CABundle.Image)
+	}
+	return corev1.Container{
+		Name:            CABundleInitContainerName,
+		Image:           image,
+		ImagePullPolicy: config.cliToolsImagePullPolicy(),
+		Command: []string{"sh", "-c", `cat "$1" "$2" > "$3"`, "sh",
+			config.CABundle.systemCAsFile(),
+			path.Join(sourceDir, DefaultCABundleKey),
+			path.Join(CABundleDirPath, DefaultCABundleKey),
+		},
+		Resources:       *config.CliToolsResources.DeepCopy(),
+		SecurityContext: config.cliToolsSecurityContext().DeepCopy(),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: CABundleSourceVolumeName, MountPath: sourceDir, ReadOnly: true},
+			{Name: CABundleVolumeName, MountPath: CABundleDirPath},
+		},
+	}
+}
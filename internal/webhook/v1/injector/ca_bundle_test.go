@@ -0,0 +1,128 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("CABundleInjector", func() {
+	var (
+		config *InjectConf
+		pod    *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.CABundle = &CABundle{Name: "dfdaemon-ca"}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app"},
+				{Name: "sidecar", Env: []corev1.EnvVar{{Name: "SSL_CERT_FILE", Value: "/etc/custom.pem"}}},
+			}},
+		}
+	})
+
+	It("should do nothing without a ca bundle", func() {
+		config.CABundle = nil
+		NewCABundleInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.Volumes).To(BeEmpty())
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+
+	It("should mount the configmap bundle and point the TLS clients to it", func() {
+		config.CABundle.Mode = CABundleModeReplace
+		NewCABundleInjector().Inject(pod, config, nil)
+
+		Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+			Name: CABundleVolumeName,
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "dfdaemon-ca"},
+				Items:                []corev1.KeyToPath{{Key: DefaultCABundleKey, Path: DefaultCABundleKey}},
+			}},
+		}))
+		Expect(pod.Spec.InitContainers).To(BeEmpty())
+		for _, c := range pod.Spec.Containers {
+			Expect(c.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+				Name: CABundleVolumeName, MountPath: CABundleDirPath, ReadOnly: true,
+			}))
+			Expect(c.Env).To(HaveLen(len(DefaultCABundleEnvNames)))
+		}
+		Expect(pod.Spec.Containers[0].Env).To(ContainElements(
+			corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/dragonfly/ca/ca.crt"},
+			corev1.EnvVar{Name: "REQUESTS_CA_BUNDLE", Value: "/etc/dragonfly/ca/ca.crt"},
+			corev1.EnvVar{Name: "NODE_EXTRA_CA_CERTS", Value: "/etc/dragonfly/ca/ca.crt"},
+			corev1.EnvVar{Name: "CURL_CA_BUNDLE", Value: "/etc/dragonfly/ca/ca.crt"},
+		))
+
+		By("keeping env vars set by the container")
+		Expect(pod.Spec.Containers[1].Env).To(ContainElement(corev1.EnvVar{Name: "SSL_CERT_FILE", Value: "/etc/custom.pem"}))
+	})
+
+	It("should mount a secret bundle with a custom key and env vars", func() {
+		config.CABundle.Mode = CABundleModeReplace
+		config.CABundle.Kind = CABundleKindSecret
+		config.CABundle.Key = "bundle.pem"
+		config.CABundle.EnvNames = []string{"AWS_CA_BUNDLE"}
+		NewCABundleInjector().Inject(pod, config, nil)
+
+		Expect(pod.Spec.Volumes[0].Secret).To(Equal(&corev1.SecretVolumeSource{
+			SecretName: "dfdaemon-ca",
+			Items:      []corev1.KeyToPath{{Key: "bundle.pem", Path: DefaultCABundleKey}},
+		}))
+		Expect(pod.Spec.Containers[0].Env).To(ConsistOf(corev1.EnvVar{Name: "AWS_CA_BUNDLE", Value: "/etc/dragonfly/ca/ca.crt"}))
+	})
+
+	It("should merge the bundle with the system CAs in an initContainer by default", func() {
+		NewCABundleInjector().Inject(pod, config, nil)
+		NewCABundleInjector().Inject(pod, config, nil)
+
+		Expect(pod.Spec.Volumes).To(HaveLen(2))
+		Expect(pod.Spec.Volumes[0].ConfigMap.Name).To(Equal("dfdaemon-ca"))
+		Expect(pod.Spec.Volumes[1].EmptyDir).NotTo(BeNil())
+		Expect(pod.Spec.InitContainers).To(HaveLen(1))
+		merge := pod.Spec.InitContainers[0]
+		Expect(merge.Name).To(Equal(CABundleInitContainerName))
+		Expect(merge.Image).To(Equal(config.CliToolsImage))
+		Expect(merge.Command).To(Equal([]string{"sh", "-c", `cat "$1" "$2" > "$3"`, "sh",
+			DefaultCABundleSystemCAsFile, "/etc/dragonfly/ca-source/ca.crt", "/etc/dragonfly/ca/ca.crt"}))
+		Expect(merge.SecurityContext).To(BeNil())
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(HaveLen(1))
+	})
+
+	It("should merge the bundle in the configured image", func() {
+		config.CABundle.Mode = CABundleModeMerge
+		config.CABundle.Image = "debian:bookworm-slim"
+		config.ImageRewrites = map[string]string{"docker.io": "mirror.local"}
+		NewCABundleInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.InitContainers[0].Image).To(Equal("mirror.local/library/debian:bookworm-slim"))
+	})
+
+	It("should validate the ca bundle", func() {
+		Expect(config.validate()).To(Succeed())
+
+		config.CABundle.Mode = "Append"
+		Expect(config.validate()).To(MatchError(ContainSubstring("invalid ca bundle mode")))
+
+		config.CABundle.Mode = ""
+		config.CABundle.Kind = "Pod"
+		Expect(config.validate()).To(MatchError(ContainSubstring("invalid ca bundle kind")))
+
+		config.CABundle.Kind = ""
+		config.CliToolsInstallMode = CliToolsInstallModeEntrypoint
+		Expect(config.validate()).To(MatchError(ContainSubstring("requires an image with a shell")))
+		config.CABundle.Mode = CABundleModeReplace
+		Expect(config.validate()).To(Succeed())
+		config.CABundle.Mode = CABundleModeMerge
+		config.CABundle.Image = "debian:bookworm-slim"
+		Expect(config.validate()).To(Succeed())
+
+		config.CABundle.SystemCAsFile = "certs.pem"
+		Expect(config.validate()).To(MatchError(ContainSubstring("must be an absolute path")))
+
+		config.CABundle.Name = ""
+		Expect(config.validate()).To(MatchError(ContainSubstring("requires a name")))
+	})
+})
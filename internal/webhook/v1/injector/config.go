@@ -2,12 +2,18 @@ package injector
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-	"sync"
+	"slices"
+	"sync/atomic"
 	"time"
 
-	"k8s.io/apimachinery/pkg/util/yaml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
@@ -28,6 +34,20 @@ const (
 	ProxyPortEnvValue int    = 4001 // Default port of dragonfly proxy
 	ProxyEnvName      string = "DRAGONFLY_INJECT_PROXY"
 
+	// Node IPs of ProxyHostHostIP, status.hostIP and the comma separated status.hostIPs
+	HostIPEnvName  string = "DRAGONFLY_HOST_IP"
+	HostIPsEnvName string = "DRAGONFLY_HOST_IPS"
+
+	// Endpoint of the dfdaemon gRPC server for pods without the unix socket, see ProxyService.GRPCPort
+	DfdaemonEndpointEnvName string = "DRAGONFLY_DFDAEMON_ENDPOINT"
+
+	// Proxy auth control, see InjectConf.ProxyAuth
+	ProxyUsernameEnvName        string = "DRAGONFLY_PROXY_USERNAME"
+	ProxyPasswordEnvName        string = "DRAGONFLY_PROXY_PASSWORD"
+	ProxyEncodedUsernameEnvName string = "DRAGONFLY_PROXY_ENCODED_USERNAME" // Percent-encoded username, see ProxyAuth.EncodedUsernameKey
+	ProxyEncodedPasswordEnvName string = "DRAGONFLY_PROXY_ENCODED_PASSWORD" // Percent-encoded password, see ProxyAuth.EncodedPasswordKey
+	ProxyAuthSecretAnnotation   string = "dragonfly.io/proxy-auth-secret"   // Namespace annotation naming a Secret of the namespace with the credentials
+
 	// Dfdaemon unix sock volume control
 	DfdaemonUnixSockVolumeName string = "dfdaemon-unix-sock"
 	DfdaemonUnixSockPath       string = "/var/run/dragonfly/dfdaemon.sock" // Default path of dfdaemon unix sock
@@ -39,17 +59,178 @@ const (
 	CliToolsVolumeName        string = CliToolsInitContainerName + "-volume"
 	CliToolsDirPath           string = "/dragonfly-tools"     // Cli tools binary directory path
 	CliToolsPathEnvName       string = "DRAGONFLY_TOOLS_PATH" // Path to the directory where binaries are injected into the container.
+
+	// CliTools volume control, the annotations override InjectConf.CliToolsVolumeMedium and CliToolsVolumeSizeLimit
+	CliToolsVolumeMediumAnnotation    string = "dragonfly.io/cli-tools-volume-medium"
+	CliToolsVolumeSizeLimitAnnotation string = "dragonfly.io/cli-tools-volume-size-limit"
+
+	// CliTools selection control, the annotation overrides InjectConf.CliTools with comma separated tool names
+	CliToolsAnnotation       string = "dragonfly.io/cli-tools"
+	CliToolsManifestFileName string = ".manifest" // File in the cli tools directory listing the tool names, one per line
+
+	// CliTools PATH control, the annotation overrides InjectConf.CliToolsAddToPath
+	CliToolsAddToPathAnnotation string = "dragonfly.io/cli-tools-add-to-path"
+	PathEnvName                 string = "PATH"
+	containerPathListSeparator  string = ":" // PATH separator of the Linux containers, not of the webhook host
+
+	// CA bundle control, see InjectConf.CABundle
+	CABundleVolumeName        string = "dragonfly-ca-bundle"
+	CABundleSourceVolumeName  string = CABundleVolumeName + "-source"
+	CABundleInitContainerName string = "d7y-ca-bundle"
+	CABundleDirPath           string = "/etc/dragonfly/ca" // Directory of the CA bundle in the containers
+
+	// Ecosystem mirrors control, the annotation enables mirrors of InjectConf.EcosystemMirrors with comma
+	// separated ecosystems, e.g. "pip,npm"
+	MirrorsAnnotation          string = "dragonfly.io/mirrors"
+	MavenSettingsConfigMapName string = "dragonfly-maven-settings" // ConfigMap kept by the webhook in the pod namespaces
+	MavenSettingsVolumeName    string = MavenSettingsConfigMapName
+	MavenSettingsDirPath       string = "/etc/dragonfly/maven"
+	MavenSettingsFileName      string = "settings.xml"
+
+	// Image builder control, the annotation sets the builders of containers of InjectConf.ImageBuilderMirrors,
+	// e.g. "build=kaniko", or "buildkit" for all containers
+	ImageBuilderAnnotation        string = "dragonfly.io/image-builder"
+	ImageBuilderConfigVolumeName  string = "dragonfly-builder-config"
+	ImageBuilderInitContainerName string = "d7y-builder-config"
+
+	// Conflict control, the annotation overrides InjectConf.ConflictPolicy with comma separated
+	// "resource=policy" entries, e.g. "env=Override,volume=Reject", or a single policy of all resources
+	ConflictPolicyAnnotation string = "dragonfly.io/conflict-policy"
+
+	// CliTools initContainer resources control, the annotations override InjectConf.CliToolsResources
+	CliToolsCPURequestAnnotation    string = "dragonfly.io/cli-tools-cpu-request"
+	CliToolsCPULimitAnnotation      string = "dragonfly.io/cli-tools-cpu-limit"
+	CliToolsMemoryRequestAnnotation string = "dragonfly.io/cli-tools-memory-request"
+	CliToolsMemoryLimitAnnotation   string = "dragonfly.io/cli-tools-memory-limit"
 )
 
+// InjectConf is the webhook config in the active schema version, see ConfigAPIVersion.
 type InjectConf struct {
-	Enable          bool   `yaml:"enable" json:"enable"`         // Whether to enable dragonfly injection
-	ProxyPort       int    `yaml:"proxy_port" json:"proxy_port"` // Proxy port of dragonfly proxy(dfdaemon proxy port)
-	CliToolsImage   string `yaml:"cli_tools_image" json:"cli_tools_image"`
-	CliToolsDirPath string `yaml:"cli_tools_dir_path" json:"cli_tools_dir_path"`
+	APIVersion      string `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"` // Schema version of the config
+	Enable          bool   `yaml:"enable" json:"enable"`                             // Whether to enable dragonfly injection
+	ProxyPort       int    `yaml:"proxyPort" json:"proxyPort"`                       // Proxy port of dragonfly proxy(dfdaemon proxy port)
+	CliToolsImage   string `yaml:"cliToolsImage" json:"cliToolsImage"`
+	CliToolsDirPath string `yaml:"cliToolsDirPath" json:"cliToolsDirPath"`
+
+	// How pods reach the dfdaemon, ProxyModeNode when unset
+	ProxyMode string `yaml:"proxyMode,omitempty" json:"proxyMode,omitempty"`
+	// Service in front of the dfdaemon DaemonSet, used by ProxyModeService
+	ProxyService *ProxyService `yaml:"proxyService,omitempty" json:"proxyService,omitempty"`
+	// Address of the node dfdaemon in ProxyModeNode, ProxyHostNodeName when unset
+	ProxyHost string `yaml:"proxyHost,omitempty" json:"proxyHost,omitempty"`
+	// IP family of the node IP of ProxyHostHostIP, IPv4 when unset. Pods bound to a node at creation get the
+	// node IP of this family, the others get status.hostIP, of the primary family of dual-stack clusters.
+	ProxyIPFamily corev1.IPFamily `yaml:"proxyIPFamily,omitempty" json:"proxyIPFamily,omitempty"`
+
+	// Basic auth of the dfdaemon proxy, the namespace annotation ProxyAuthSecretAnnotation overrides the
+	// Secret, the proxy URL has no credentials when both are unset
+	ProxyAuth *ProxyAuth `yaml:"proxyAuth,omitempty" json:"proxyAuth,omitempty"`
+
+	// How the cli tools are delivered into the pods, CliToolsDeliveryModeInitContainer when unset
+	CliToolsDeliveryMode string `yaml:"cliToolsDeliveryMode,omitempty" json:"cliToolsDeliveryMode,omitempty"`
+	// Node directory caching the cli tools for CliToolsDeliveryModeHostPath, DefaultCliToolsHostPath when unset. Its
+	// subdirectories are keyed by the cli tools image tag or digest, and populated by the node agent.
+	CliToolsHostPath string `yaml:"cliToolsHostPath,omitempty" json:"cliToolsHostPath,omitempty"`
+	// First server version CliToolsDeliveryModeAuto uses image volumes on, DefaultImageVolumeMinVersion when unset
+	ImageVolumeMinVersion string `yaml:"imageVolumeMinVersion,omitempty" json:"imageVolumeMinVersion,omitempty"`
+
+	// Medium of the cli tools emptyDir, "Memory" for tmpfs, the node's default medium when unset
+	CliToolsVolumeMedium corev1.StorageMedium `yaml:"cliToolsVolumeMedium,omitempty" json:"cliToolsVolumeMedium,omitempty"`
+	// Size limit of the cli tools emptyDir, also requested as ephemeral storage by the initContainer on disk
+	CliToolsVolumeSizeLimit *resource.Quantity `yaml:"cliToolsVolumeSizeLimit,omitempty" json:"cliToolsVolumeSizeLimit,omitempty"`
+
+	// How the initContainer installs the tools, CliToolsInstallModeCopy when unset
+	CliToolsInstallMode string `yaml:"cliToolsInstallMode,omitempty" json:"cliToolsInstallMode,omitempty"`
+
+	// Names of the cli tools copied into the pods, all files of CliToolsDirPath when empty. The names are checked
+	// against the manifest of the cli tools image, see CliToolsManifestFileName. Overridable by the CliToolsAnnotation
+	CliTools []string `yaml:"cliTools,omitempty" json:"cliTools,omitempty"`
+
+	// Whether to prepend the cli tools directory to the PATH of the containers, overridable by the CliToolsAddToPathAnnotation
+	CliToolsAddToPath bool `yaml:"cliToolsAddToPath,omitempty" json:"cliToolsAddToPath,omitempty"`
+	// PATH of the containers that don't set PATH in their env. The PATH of the image can't be read at admission
+	// time, so the PATH of these containers is only changed when it is set, the admission warns about them otherwise.
+	CliToolsBasePath string `yaml:"cliToolsBasePath,omitempty" json:"cliToolsBasePath,omitempty"`
+
+	// Resources of the cli tools initContainer, overridable by the CliTools*Annotation annotations
+	CliToolsResources corev1.ResourceRequirements `yaml:"cliToolsResources,omitempty" json:"cliToolsResources,omitempty"`
+	// Whether to fill resources missing from CliToolsResources with the namespace LimitRange defaults
+	CliToolsResourcesFromLimitRange bool `yaml:"cliToolsResourcesFromLimitRange,omitempty" json:"cliToolsResourcesFromLimitRange,omitempty"`
+	// Security context of the cli tools initContainer, none when unset unless CliToolsRestrictedSecurityContext
+	CliToolsSecurityContext *corev1.SecurityContext `yaml:"cliToolsSecurityContext,omitempty" json:"cliToolsSecurityContext,omitempty"`
+	// Whether to use NewRestrictedCliToolsSecurityContext when CliToolsSecurityContext is unset
+	CliToolsRestrictedSecurityContext bool `yaml:"cliToolsRestrictedSecurityContext,omitempty" json:"cliToolsRestrictedSecurityContext,omitempty"`
+	// Pod Security Standard level of the namespaces without the PodSecurityEnforceLabelName label, the default
+	// of the PodSecurity admission plugin of the cluster, PodSecurityLevelPrivileged when unset
+	PodSecurityDefaultLevel string `yaml:"podSecurityDefaultLevel,omitempty" json:"podSecurityDefaultLevel,omitempty"`
+
+	// Cli tools images by architecture, e.g. "arm64": "dragonflyoss/cli-tools-arm64-linux:latest", for registries
+	// without manifest lists. The architecture comes from the pod's kubernetes.io/arch nodeSelector or required
+	// node affinity, pods with an ambiguous architecture get CliToolsImage unless CliToolsArchAction rejects them
+	CliToolsArchImages map[string]string `yaml:"cliToolsArchImages,omitempty" json:"cliToolsArchImages,omitempty"`
+	// What to do with pods whose architecture has no image in CliToolsArchImages, ImagePolicyActionIgnore when unset
+	CliToolsArchAction string `yaml:"cliToolsArchAction,omitempty" json:"cliToolsArchAction,omitempty"`
+
+	// Restricts the images pods can request with the CliToolsImageAnnotation, any image is allowed when unset
+	CliToolsImagePolicy *CliToolsImagePolicy `yaml:"cliToolsImagePolicy,omitempty" json:"cliToolsImagePolicy,omitempty"`
+
+	// Image name prefixes replaced in every image the webhook injects, e.g. "docker.io/dragonflyoss" to
+	// "harbor.internal/dragonfly". Prefixes are normalized and the longest matching prefix wins.
+	ImageRewrites map[string]string `yaml:"imageRewrites,omitempty" json:"imageRewrites,omitempty"`
+
+	// Pins the cli tools image to the digest of its tag at admission time, the tag is injected when unset
+	CliToolsImageResolution *CliToolsImageResolution `yaml:"cliToolsImageResolution,omitempty" json:"cliToolsImageResolution,omitempty"`
+
+	// Checks the cli tools image against the version of the running dfdaemon, no check when unset
+	DfdaemonCompatibility *DfdaemonCompatibility `yaml:"dfdaemonCompatibility,omitempty" json:"dfdaemonCompatibility,omitempty"`
+
+	// Pull policy of the cli tools image, IfNotPresent when unset
+	CliToolsImagePullPolicy corev1.PullPolicy `yaml:"cliToolsImagePullPolicy,omitempty" json:"cliToolsImagePullPolicy,omitempty"`
+	// Names of the secrets added to the pod imagePullSecrets for pulling the cli tools image
+	CliToolsImagePullSecrets []string `yaml:"cliToolsImagePullSecrets,omitempty" json:"cliToolsImagePullSecrets,omitempty"`
+	// Pull secret copied into the pod namespace under the same name and added to the pod imagePullSecrets
+	CliToolsImagePullSecretSource *corev1.SecretReference `yaml:"cliToolsImagePullSecretSource,omitempty" json:"cliToolsImagePullSecretSource,omitempty"`
+
+	// Package registry mirrors served by the node dfdaemon, enabled per pod with MirrorsAnnotation
+	EcosystemMirrors *EcosystemMirrors `yaml:"ecosystemMirrors,omitempty" json:"ecosystemMirrors,omitempty"`
+
+	// Registry mirror of the node dfdaemon injected into image builder containers, none when unset
+	ImageBuilderMirrors *ImageBuilderMirrors `yaml:"imageBuilderMirrors,omitempty" json:"imageBuilderMirrors,omitempty"`
+
+	// CA bundle of the dfdaemon proxy mounted into the containers, none when unset
+	CABundle *CABundle `yaml:"caBundle,omitempty" json:"caBundle,omitempty"`
+
+	// Whether to skip mounting the dfdaemon unix socket, it is always skipped in namespaces
+	// enforcing the baseline or restricted Pod Security Standard, which forbid hostPath volumes
+	DisableUnixSocket bool `yaml:"disableUnixSocket,omitempty" json:"disableUnixSocket,omitempty"`
+
+	// Whether to skip injecting the cli tools
+	DisableCliTools bool `yaml:"disableCliTools,omitempty" json:"disableCliTools,omitempty"`
+
+	// What to do with env vars, volumes and initContainers of the pod that differ from the injected ones
+	// under the same name, ConflictPolicyPreserve for all when unset. Overridable by the ConflictPolicyAnnotation
+	ConflictPolicy *ConflictPolicy `yaml:"conflictPolicy,omitempty" json:"conflictPolicy,omitempty"`
+
+	// How pods with hostNetwork: true are injected, the same as other pods when unset
+	HostNetwork *HostNetworkProfile `yaml:"hostNetwork,omitempty" json:"hostNetwork,omitempty"`
+
+	// compiled holds data derived from the config. It is computed once per reload, so
+	// injectors don't have to rebuild it on every admission request.
+	compiled *compiledConf
+}
+
+// compiledConf is the data derived from an InjectConf, see InjectConf.compile.
+type compiledConf struct {
+	proxyEnvs               []corev1.EnvVar
+	cliToolsVolumeMountPath string
+	imagePolicy             *compiledImagePolicy
+	imageRewrites           []imageRewriteRule
+	cliToolsImageRegistries []string // registries of the configured images, the effective config may override them
 }
 
 func NewDefaultInjectConf() *InjectConf {
 	return &InjectConf{
+		APIVersion:      ConfigAPIVersion,
 		Enable:          true,
 		ProxyPort:       ProxyPortEnvValue,
 		CliToolsImage:   CliToolsImage,
@@ -57,28 +238,238 @@ func NewDefaultInjectConf() *InjectConf {
 	}
 }
 
+// compile computes the derived data of the config. The config must not be modified afterwards.
+func (c *InjectConf) compile() {
+	c.compiled = &compiledConf{
+		proxyEnvs:               envsFromConfig(c, nil),
+		cliToolsVolumeMountPath: cliToolsVolumeMountPath(c),
+		imagePolicy:             c.CliToolsImagePolicy.compile(),
+		imageRewrites:           compileImageRewrites(c.ImageRewrites),
+	}
+	c.compiled.cliToolsImageRegistries = cliToolsImageRegistries(c)
+}
+
+// withOverrides returns the derived data of the effective config. Only the image rules and registries
+// of the loaded config are kept, the rest is recomputed.
+func (cc *compiledConf) withOverrides(effective *InjectConf) *compiledConf {
+	if cc == nil {
+		return nil
+	}
+	return &compiledConf{
+		proxyEnvs:               envsFromConfig(effective, nil),
+		cliToolsVolumeMountPath: cliToolsVolumeMountPath(effective),
+		imagePolicy:             cc.imagePolicy,
+		imageRewrites:           cc.imageRewrites,
+		cliToolsImageRegistries: cc.cliToolsImageRegistries,
+	}
+}
+
+// validate checks the config for values the injectors can't handle.
+func (c *InjectConf) validate() error {
+	if c.CliToolsImagePolicy != nil {
+		if err := c.CliToolsImagePolicy.validate(); err != nil {
+			return err
+		}
+	}
+	for _, tool := range c.CliTools {
+		if !validCliToolName(tool) {
+			return fmt.Errorf("invalid cli tool name %q", tool)
+		}
+	}
+	switch c.PodSecurityDefaultLevel {
+	case "", PodSecurityLevelPrivileged, PodSecurityLevelBaseline, PodSecurityLevelRestricted:
+	default:
+		return fmt.Errorf("invalid pod security default level %q", c.PodSecurityDefaultLevel)
+	}
+	switch c.CliToolsArchAction {
+	case "", ImagePolicyActionIgnore, ImagePolicyActionReject:
+	default:
+		return fmt.Errorf("invalid cli tools arch action %q", c.CliToolsArchAction)
+	}
+	if c.CliToolsImageResolution != nil {
+		if err := c.CliToolsImageResolution.validate(); err != nil {
+			return err
+		}
+	}
+	if c.DfdaemonCompatibility != nil {
+		if err := c.DfdaemonCompatibility.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateProxyMode(c); err != nil {
+		return err
+	}
+	if err := validateProxyHost(c); err != nil {
+		return err
+	}
+	if c.ProxyAuth != nil {
+		if err := c.ProxyAuth.validate(); err != nil {
+			return err
+		}
+	}
+	if c.EcosystemMirrors != nil {
+		if err := c.EcosystemMirrors.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ImageBuilderMirrors != nil {
+		if err := c.ImageBuilderMirrors.validate(); err != nil {
+			return err
+		}
+	}
+	if c.CABundle != nil {
+		if err := c.CABundle.validate(); err != nil {
+			return err
+		}
+		if c.CABundle.mode() == CABundleModeMerge && c.CABundle.Image == "" && c.CliToolsInstallMode == CliToolsInstallModeEntrypoint {
+			return errors.New("ca bundle merge mode requires an image with a shell with the Entrypoint install mode")
+		}
+	}
+	if c.ConflictPolicy != nil {
+		if err := c.ConflictPolicy.validate(); err != nil {
+			return err
+		}
+	}
+	if c.HostNetwork != nil {
+		if err := c.HostNetwork.validate(); err != nil {
+			return err
+		}
+	}
+	if !validCliToolsVolumeMedium(c.CliToolsVolumeMedium) {
+		return fmt.Errorf("invalid cli tools volume medium %q", c.CliToolsVolumeMedium)
+	}
+	if err := validateCliToolsDelivery(c); err != nil {
+		return err
+	}
+	return validateImageRewrites(c.ImageRewrites)
+}
+
+// proxyEnvs returns the proxy env vars of the config for the admission, which may be nil, computing them
+// if the config is not compiled or the admission replaces the host of the dfdaemon.
+func (c *InjectConf) proxyEnvs(admission *PodAdmission) []corev1.EnvVar {
+	if c.compiled != nil && !admission.overridesDfdaemonHost() {
+		return c.compiled.proxyEnvs
+	}
+	return envsFromConfig(c, admission)
+}
+
+// cliToolsVolumeMountPath returns the cli tools mount path of the config, computing it if the config is not compiled.
+func (c *InjectConf) cliToolsVolumeMountPath() string {
+	if c.compiled != nil {
+		return c.compiled.cliToolsVolumeMountPath
+	}
+	return cliToolsVolumeMountPath(c)
+}
+
+// rewriteImage applies the image rewrite rules to an image the webhook injects.
+func (c *InjectConf) rewriteImage(image string) string {
+	if c.compiled != nil {
+		return rewriteImage(c.compiled.imageRewrites, image)
+	}
+	return rewriteImage(compileImageRewrites(c.ImageRewrites), image)
+}
+
+// validCliToolsVolumeMedium reports whether the medium can back the cli tools emptyDir.
+func validCliToolsVolumeMedium(medium corev1.StorageMedium) bool {
+	return medium == corev1.StorageMediumDefault || medium == corev1.StorageMediumMemory
+}
+
+// cliToolsInitContainerResources returns the resources of the initContainer. A disk backed emptyDir
+// with a size limit adds a matching ephemeral storage request, unless one is configured.
+func (c *InjectConf) cliToolsInitContainerResources() corev1.ResourceRequirements {
+	resources := *c.CliToolsResources.DeepCopy()
+	if c.CliToolsVolumeSizeLimit == nil || c.CliToolsVolumeMedium == corev1.StorageMediumMemory {
+		return resources
+	}
+	if _, ok := resources.Requests[corev1.ResourceEphemeralStorage]; ok {
+		return resources
+	}
+	request := c.CliToolsVolumeSizeLimit.DeepCopy()
+	if limit, ok := resources.Limits[corev1.ResourceEphemeralStorage]; ok && request.Cmp(limit) > 0 {
+		request = limit
+	}
+	if resources.Requests == nil {
+		resources.Requests = corev1.ResourceList{}
+	}
+	resources.Requests[corev1.ResourceEphemeralStorage] = request
+	return resources
+}
+
+// cliToolsSecurityContext returns the configured cli tools security context, the restricted one if enabled, or nil.
+func (c *InjectConf) cliToolsSecurityContext() *corev1.SecurityContext {
+	if c.CliToolsSecurityContext != nil {
+		return c.CliToolsSecurityContext
+	}
+	if c.CliToolsRestrictedSecurityContext {
+		return NewRestrictedCliToolsSecurityContext()
+	}
+	return nil
+}
+
+// cliToolsImagePullPolicy returns the configured cli tools image pull policy, or IfNotPresent.
+func (c *InjectConf) cliToolsImagePullPolicy() corev1.PullPolicy {
+	if c.CliToolsImagePullPolicy != "" {
+		return c.CliToolsImagePullPolicy
+	}
+	return corev1.PullIfNotPresent
+}
+
+// cliToolsImagePullSecrets returns the names of all pull secrets of the cli tools image.
+func (c *InjectConf) cliToolsImagePullSecrets() []string {
+	if c.CliToolsImagePullSecretSource == nil || c.CliToolsImagePullSecretSource.Name == "" {
+		return c.CliToolsImagePullSecrets
+	}
+	return append(slices.Clip(c.CliToolsImagePullSecrets), c.CliToolsImagePullSecretSource.Name)
+}
+
+// ConfigSourceDefault is the source of a config snapshot built from the built-in defaults.
+const ConfigSourceDefault string = "default"
+
+// ConfigSnapshot is an immutable view of a loaded config and its provenance. Snapshots are
+// shared by all admission requests and must never be modified once published.
+type ConfigSnapshot struct {
+	Config          *InjectConf `json:"config"`
+	APIVersion      string      `json:"apiVersion"`                // Schema version the config file was written in
+	Generation      uint64      `json:"generation"`                // Incremented on every reload that changes the config
+	Source          string      `json:"source"`                    // Path of the config file, or ConfigSourceDefault
+	ContentHash     string      `json:"contentHash,omitempty"`     // Hash of the config file content
+	LoadedAt        time.Time   `json:"loadedAt"`                  // Time the config was loaded
+	LastReloadError string      `json:"lastReloadError,omitempty"` // Why the config file could not be used, if it couldn't, the config is then the last loaded one
+}
+
+// sameSource reports whether both snapshots were loaded from identical input.
+func (s *ConfigSnapshot) sameSource(other *ConfigSnapshot) bool {
+	return s.Source == other.Source &&
+		s.ContentHash == other.ContentHash &&
+		s.LastReloadError == other.LastReloadError
+}
+
 type ConfigManager struct {
-	mu         sync.RWMutex
-	config     *InjectConf
+	snapshot   atomic.Pointer[ConfigSnapshot]
 	configPath string
 }
 
 func NewConfigManager(injectConfigMapPath string) *ConfigManager {
-	configPath := filepath.Join(injectConfigMapPath, "config.yaml")
-	return &ConfigManager{
-		mu:         sync.RWMutex{},
-		config:     LoadInjectConf(configPath),
-		configPath: configPath,
+	cm := &ConfigManager{
+		configPath: filepath.Join(injectConfigMapPath, "config.yaml"),
 	}
+	cm.reload()
+	return cm
+}
+
+// Snapshot returns the current config snapshot without locking.
+func (cm *ConfigManager) Snapshot() *ConfigSnapshot {
+	return cm.snapshot.Load()
 }
 
+// GetConfig returns the current config. The returned config is shared and must not be modified.
 func (cm *ConfigManager) GetConfig() *InjectConf {
-	cm.mu.RLock()
-	defer cm.mu.RUnlock()
+	return cm.Snapshot().Config
+}
 
-	copiedConf := *cm.config
-	podlog.Info("Get config", "config", copiedConf)
-	return &copiedConf
+// APIVersion returns the schema version the loaded config file was written in.
+func (cm *ConfigManager) APIVersion() string {
+	return cm.Snapshot().APIVersion
 }
 
 func (cm *ConfigManager) Start(ctx context.Context) error {
@@ -92,28 +483,80 @@ func (cm *ConfigManager) Start(ctx context.Context) error {
 			podlog.Info("Stopping config file watcher.")
 			return nil
 		case <-ticker.C:
-			podlog.Info("Periodic reload check.")
+			podlog.V(1).Info("Periodic reload check.")
 			cm.reload()
 		}
 	}
 }
 
 func (cm *ConfigManager) reload() {
-	config := LoadInjectConf(cm.configPath)
-	cm.mu.Lock()
-	defer cm.mu.Unlock()
-	cm.config = config
-	podlog.Info("Configuration reloaded successfully.")
+	next := loadConfigSnapshot(cm.configPath)
+
+	// Publish with a CAS loop, so concurrent reloads never move the generation backwards.
+	for {
+		current := cm.snapshot.Load()
+		if current != nil && next.LastReloadError != "" && current.Source != ConfigSourceDefault {
+			// Keep the last loaded config, falling back to the defaults would drop its policies.
+			if current.LastReloadError == next.LastReloadError {
+				return
+			}
+			kept := *current
+			kept.LastReloadError = next.LastReloadError
+			if cm.snapshot.CompareAndSwap(current, &kept) {
+				podlog.Info("Configuration reload failed, keeping the last loaded config.",
+					"generation", kept.Generation, "source", kept.Source, "error", kept.LastReloadError)
+				return
+			}
+			continue
+		}
+		if current != nil && current.sameSource(next) {
+			podlog.V(1).Info("Configuration unchanged.", "generation", current.Generation)
+			return
+		}
+		next.Generation = 1
+		if current != nil {
+			next.Generation = current.Generation + 1
+		}
+		if cm.snapshot.CompareAndSwap(current, next) {
+			podlog.Info("Configuration reloaded successfully.",
+				"generation", next.Generation, "apiVersion", next.APIVersion,
+				"source", next.Source, "contentHash", next.ContentHash, "config", next.Config)
+			return
+		}
+	}
 }
 
-func LoadInjectConf(injectConfigMapPath string) *InjectConf {
-	ic, err := LoadInjectConfFromFile(injectConfigMapPath)
+// loadConfigSnapshot loads the config file into an unpublished snapshot, falling back to the
+// default config if the file can't be used. Reloads keep the last loaded config instead, see reload.
+func loadConfigSnapshot(configPath string) *ConfigSnapshot {
+	snapshot := &ConfigSnapshot{
+		Source:   configPath,
+		LoadedAt: time.Now(),
+	}
+
+	cf, err := os.ReadFile(configPath)
+	if err == nil {
+		sum := sha256.Sum256(cf)
+		snapshot.ContentHash = "sha256:" + hex.EncodeToString(sum[:])
+		snapshot.Config, snapshot.APIVersion, err = DecodeInjectConf(cf)
+	}
 	if err != nil {
 		podlog.Error(err, "load config from file failed")
-		podlog.Info("use default config")
-		ic = NewDefaultInjectConf()
+		snapshot.Config = NewDefaultInjectConf()
+		snapshot.APIVersion = ConfigAPIVersion
+		snapshot.Source = ConfigSourceDefault
+		snapshot.LastReloadError = err.Error()
+	} else if snapshot.APIVersion != ConfigAPIVersion {
+		podlog.Info("config file uses a deprecated schema version, converted automatically",
+			"apiVersion", snapshot.APIVersion, "activeAPIVersion", ConfigAPIVersion)
 	}
-	return ic
+
+	snapshot.Config.compile()
+	return snapshot
+}
+
+func LoadInjectConf(injectConfigMapPath string) *InjectConf {
+	return loadConfigSnapshot(injectConfigMapPath).Config
 }
 
 // load inject config from file
@@ -122,10 +565,6 @@ func LoadInjectConfFromFile(injectConfigMapPath string) (*InjectConf, error) {
 	if err != nil {
 		return nil, err
 	}
-	injectConf := &InjectConf{}
-	if err := yaml.Unmarshal(cf, injectConf); err != nil {
-		return nil, err
-	}
-
-	return injectConf, nil
+	injectConf, _, err := DecodeInjectConf(cf)
+	return injectConf, err
 }
@@ -0,0 +1,65 @@
+package injector
+
+import (
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// lockedConfigManager mirrors the previous ConfigManager.GetConfig, which took a read lock,
+// copied the config and logged it on every call. It is kept as a baseline for the benchmarks.
+type lockedConfigManager struct {
+	mu     sync.RWMutex
+	config *InjectConf
+}
+
+func (cm *lockedConfigManager) GetConfig() *InjectConf {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	copiedConf := *cm.config
+	podlog.Info("Get config", "config", copiedConf)
+	return &copiedConf
+}
+
+func BenchmarkGetConfigLocked(b *testing.B) {
+	cm := &lockedConfigManager{config: NewDefaultInjectConf()}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = cm.GetConfig()
+		}
+	})
+}
+
+func BenchmarkGetConfigSnapshot(b *testing.B) {
+	cm := NewConfigManager(b.TempDir())
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = cm.GetConfig()
+		}
+	})
+}
+
+func benchmarkProxyEnvInject(b *testing.B, config *InjectConf) {
+	pei := NewProxyEnvInjector()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+		pei.Inject(pod, config, nil)
+	}
+}
+
+func BenchmarkProxyEnvInjectUncompiled(b *testing.B) {
+	benchmarkProxyEnvInject(b, NewDefaultInjectConf())
+}
+
+func BenchmarkProxyEnvInjectCompiled(b *testing.B) {
+	config := NewDefaultInjectConf()
+	config.compile()
+	benchmarkProxyEnvInject(b, config)
+}
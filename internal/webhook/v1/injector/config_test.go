@@ -2,6 +2,8 @@ package injector
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"path/filepath"
 	"time"
@@ -26,6 +28,7 @@ var _ = Describe("Config", func() {
 				By("creating a valid config file")
 				configPath := filepath.Join(tempDir, "valid-config.yaml")
 				configData := &InjectConf{
+					APIVersion:      ConfigAPIVersion,
 					Enable:          true,
 					ProxyPort:       8080,
 					CliToolsImage:   "test-image:latest",
@@ -69,7 +72,7 @@ var _ = Describe("Config", func() {
 			It("should handle partial config with zero values", func() {
 				By("creating a partial config file")
 				configPath := filepath.Join(tempDir, "partial-config.yaml")
-				partialConfig := &InjectConf{Enable: true}
+				partialConfig := &InjectConf{APIVersion: ConfigAPIVersion, Enable: true}
 				yamlData, err := yaml.Marshal(partialConfig)
 				Expect(err).NotTo(HaveOccurred())
 				err = os.WriteFile(configPath, yamlData, 0644)
@@ -94,8 +97,9 @@ var _ = Describe("Config", func() {
 				By("creating an existing config file")
 				configPath := filepath.Join(tempDir, "existing-config.yaml")
 				configData := &InjectConf{
-					Enable:    false,
-					ProxyPort: 1234,
+					APIVersion: ConfigAPIVersion,
+					Enable:     false,
+					ProxyPort:  1234,
 				}
 				yamlData, err := yaml.Marshal(configData)
 				Expect(err).NotTo(HaveOccurred())
@@ -163,6 +167,7 @@ var _ = Describe("Config", func() {
 				By("creating initial configuration")
 				configPath := filepath.Join(tempDir, "config.yaml")
 				initialConfig := &InjectConf{
+					APIVersion:      ConfigAPIVersion,
 					Enable:          true,
 					ProxyPort:       3000,
 					CliToolsImage:   "initial:latest",
@@ -192,8 +197,9 @@ var _ = Describe("Config", func() {
 			It("should reload configuration correctly", func() {
 				By("updating the configuration file")
 				updatedConfig := &InjectConf{
-					Enable:    false,
-					ProxyPort: 9999,
+					APIVersion: ConfigAPIVersion,
+					Enable:     false,
+					ProxyPort:  9999,
 				}
 				data, err := yaml.Marshal(updatedConfig)
 				Expect(err).NotTo(HaveOccurred())
@@ -211,6 +217,113 @@ var _ = Describe("Config", func() {
 			})
 		})
 
+		Context("with config snapshots", func() {
+			It("should publish a new immutable snapshot when the config changes", func() {
+				By("creating the ConfigManager")
+				configPath := filepath.Join(tempDir, "config.yaml")
+				Expect(os.WriteFile(configPath, []byte("proxy_port: 1000\n"), 0644)).To(Succeed())
+				configManager := NewConfigManager(tempDir)
+				first := configManager.Snapshot()
+				Expect(first.Generation).To(Equal(uint64(1)))
+				Expect(first.LoadedAt).NotTo(BeZero())
+
+				By("reloading the unchanged configuration")
+				configManager.reload()
+				Expect(configManager.Snapshot()).To(BeIdenticalTo(first))
+
+				By("reloading the changed configuration")
+				Expect(os.WriteFile(configPath, []byte("proxy_port: 2000\n"), 0644)).To(Succeed())
+				configManager.reload()
+				second := configManager.Snapshot()
+
+				By("verifying the generation increased and the old snapshot is untouched")
+				Expect(second.Generation).To(Equal(uint64(2)))
+				Expect(second).NotTo(BeIdenticalTo(first))
+				Expect(first.Generation).To(Equal(uint64(1)))
+			})
+
+			It("should record the provenance of a loaded config file", func() {
+				By("creating the ConfigManager from a config file")
+				configPath := filepath.Join(tempDir, "config.yaml")
+				content := []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPort: 1000\n")
+				Expect(os.WriteFile(configPath, content, 0644)).To(Succeed())
+				configManager := NewConfigManager(tempDir)
+
+				By("verifying the provenance")
+				sum := sha256.Sum256(content)
+				snapshot := configManager.Snapshot()
+				Expect(snapshot.Source).To(Equal(configPath))
+				Expect(snapshot.ContentHash).To(Equal("sha256:" + hex.EncodeToString(sum[:])))
+				Expect(snapshot.LastReloadError).To(BeEmpty())
+			})
+
+			It("should record the reload error when falling back to defaults", func() {
+				By("creating the ConfigManager from an invalid config file")
+				configPath := filepath.Join(tempDir, "config.yaml")
+				Expect(os.WriteFile(configPath, []byte("proxyPort: [\n"), 0644)).To(Succeed())
+				configManager := NewConfigManager(tempDir)
+
+				By("verifying the defaults and the error are recorded")
+				snapshot := configManager.Snapshot()
+				Expect(snapshot.Source).To(Equal(ConfigSourceDefault))
+				Expect(snapshot.ContentHash).NotTo(BeEmpty())
+				Expect(snapshot.LastReloadError).NotTo(BeEmpty())
+				Expect(snapshot.Config.ProxyPort).To(Equal(ProxyPortEnvValue))
+
+				By("fixing the config file")
+				Expect(os.WriteFile(configPath, []byte("proxy_port: 1000\n"), 0644)).To(Succeed())
+				configManager.reload()
+				Expect(configManager.Snapshot().LastReloadError).To(BeEmpty())
+				Expect(configManager.GetConfig().ProxyPort).To(Equal(1000))
+			})
+
+			It("should keep the last loaded config when a reload fails", func() {
+				By("creating the ConfigManager from a config file")
+				configPath := filepath.Join(tempDir, "config.yaml")
+				content := []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPort: 1000\n")
+				Expect(os.WriteFile(configPath, content, 0644)).To(Succeed())
+				configManager := NewConfigManager(tempDir)
+				first := configManager.Snapshot()
+
+				By("reloading a config file with an unknown field")
+				Expect(os.WriteFile(configPath, []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPrt: 2000\n"), 0644)).To(Succeed())
+				configManager.reload()
+				failed := configManager.Snapshot()
+				Expect(failed.LastReloadError).NotTo(BeEmpty())
+				Expect(failed.Config).To(BeIdenticalTo(first.Config))
+				Expect(failed.Source).To(Equal(configPath))
+				Expect(failed.Generation).To(Equal(first.Generation))
+				Expect(first.LastReloadError).To(BeEmpty())
+
+				By("reloading the same invalid file")
+				configManager.reload()
+				Expect(configManager.Snapshot()).To(BeIdenticalTo(failed))
+
+				By("fixing the config file")
+				Expect(os.WriteFile(configPath, []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPort: 3000\n"), 0644)).To(Succeed())
+				configManager.reload()
+				Expect(configManager.Snapshot().LastReloadError).To(BeEmpty())
+				Expect(configManager.GetConfig().ProxyPort).To(Equal(3000))
+			})
+
+			It("should return the shared config of the current snapshot", func() {
+				configManager := NewConfigManager(tempDir)
+				Expect(configManager.GetConfig()).To(BeIdenticalTo(configManager.Snapshot().Config))
+				Expect(configManager.GetConfig()).To(BeIdenticalTo(configManager.GetConfig()))
+			})
+
+			It("should compute derived data once per reload", func() {
+				By("creating the ConfigManager")
+				configManager := NewConfigManager(tempDir)
+				config := configManager.GetConfig()
+
+				By("verifying the derived data is precomputed")
+				Expect(config.compiled).NotTo(BeNil())
+				Expect(config.proxyEnvs(nil)).To(Equal(envsFromConfig(config, nil)))
+				Expect(config.cliToolsVolumeMountPath()).To(Equal("/dragonfly-tools-mount"))
+			})
+		})
+
 		Context("when configuration file does not exist", func() {
 			It("should use default configuration", func() {
 				By("creating ConfigManager without config file")
@@ -257,6 +370,7 @@ var _ = Describe("Config", func() {
 				By("creating initial configuration for concurrent testing")
 				configPath := filepath.Join(tempDir, "config.yaml")
 				configData := &InjectConf{
+					APIVersion:      ConfigAPIVersion,
 					Enable:          true,
 					ProxyPort:       3000,
 					CliToolsImage:   "initial:latest",
@@ -0,0 +1,70 @@
+package injector
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// ConfigAPIVersionV1Alpha1 is the original flat, snake_case config format. Config files
+	// without an apiVersion are treated as this version.
+	ConfigAPIVersionV1Alpha1 string = "webhook.d7y.io/v1alpha1"
+	// ConfigAPIVersionV1Alpha2 is the versioned, camelCase config format.
+	ConfigAPIVersionV1Alpha2 string = "webhook.d7y.io/v1alpha2"
+	// ConfigAPIVersion is the active config schema version, every loaded config is converted to it.
+	ConfigAPIVersion string = ConfigAPIVersionV1Alpha2
+)
+
+// configTypeMeta is used to detect the schema version of a config file before decoding it.
+type configTypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// injectConfV1Alpha1 is the legacy unversioned config format.
+type injectConfV1Alpha1 struct {
+	APIVersion      string `json:"apiVersion,omitempty"`
+	Enable          bool   `json:"enable"`
+	ProxyPort       int    `json:"proxy_port"`
+	CliToolsImage   string `json:"cli_tools_image"`
+	CliToolsDirPath string `json:"cli_tools_dir_path"`
+}
+
+func (c *injectConfV1Alpha1) convert() *InjectConf {
+	return &InjectConf{
+		APIVersion:      ConfigAPIVersion,
+		Enable:          c.Enable,
+		ProxyPort:       c.ProxyPort,
+		CliToolsImage:   c.CliToolsImage,
+		CliToolsDirPath: c.CliToolsDirPath,
+	}
+}
+
+// DecodeInjectConf strictly decodes a config document of any supported schema version, converts it to
+// the active schema version and returns it together with the version the document was written in.
+func DecodeInjectConf(data []byte) (*InjectConf, string, error) {
+	typeMeta := &configTypeMeta{}
+	if err := yaml.Unmarshal(data, typeMeta); err != nil {
+		return nil, "", err
+	}
+
+	switch typeMeta.APIVersion {
+	case "", ConfigAPIVersionV1Alpha1:
+		legacyConf := &injectConfV1Alpha1{}
+		if err := yaml.UnmarshalStrict(data, legacyConf); err != nil {
+			return nil, "", fmt.Errorf("decode %s config: %w", ConfigAPIVersionV1Alpha1, err)
+		}
+		return legacyConf.convert(), ConfigAPIVersionV1Alpha1, nil
+	case ConfigAPIVersionV1Alpha2:
+		injectConf := &InjectConf{}
+		if err := yaml.UnmarshalStrict(data, injectConf); err != nil {
+			return nil, "", fmt.Errorf("decode %s config: %w", ConfigAPIVersionV1Alpha2, err)
+		}
+		if err := injectConf.validate(); err != nil {
+			return nil, "", fmt.Errorf("invalid %s config: %w", ConfigAPIVersionV1Alpha2, err)
+		}
+		return injectConf, ConfigAPIVersionV1Alpha2, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported config apiVersion %q", typeMeta.APIVersion)
+	}
+}
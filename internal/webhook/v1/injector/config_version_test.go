@@ -0,0 +1,107 @@
+package injector
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config versioning", func() {
+	Describe("DecodeInjectConf", func() {
+		It("should convert a legacy unversioned config", func() {
+			By("decoding a config without apiVersion")
+			data := []byte("enable: true\nproxy_port: 8001\ncli_tools_image: legacy:latest\ncli_tools_dir_path: /legacy\n")
+			config, apiVersion, err := DecodeInjectConf(data)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("verifying the config is converted to the active version")
+			Expect(apiVersion).To(Equal(ConfigAPIVersionV1Alpha1))
+			Expect(config).To(Equal(&InjectConf{
+				APIVersion:      ConfigAPIVersion,
+				Enable:          true,
+				ProxyPort:       8001,
+				CliToolsImage:   "legacy:latest",
+				CliToolsDirPath: "/legacy",
+			}))
+		})
+
+		It("should convert an explicit v1alpha1 config", func() {
+			data := []byte("apiVersion: webhook.d7y.io/v1alpha1\nproxy_port: 8002\n")
+			config, apiVersion, err := DecodeInjectConf(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apiVersion).To(Equal(ConfigAPIVersionV1Alpha1))
+			Expect(config.APIVersion).To(Equal(ConfigAPIVersion))
+			Expect(config.ProxyPort).To(Equal(8002))
+		})
+
+		It("should decode a v1alpha2 config", func() {
+			data := []byte("apiVersion: webhook.d7y.io/v1alpha2\nenable: true\nproxyPort: 8003\n" +
+				"cliToolsImage: current:latest\ncliToolsDirPath: /current\n")
+			config, apiVersion, err := DecodeInjectConf(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apiVersion).To(Equal(ConfigAPIVersionV1Alpha2))
+			Expect(config).To(Equal(&InjectConf{
+				APIVersion:      ConfigAPIVersionV1Alpha2,
+				Enable:          true,
+				ProxyPort:       8003,
+				CliToolsImage:   "current:latest",
+				CliToolsDirPath: "/current",
+			}))
+		})
+
+		It("should decode Kubernetes quantities", func() {
+			config, _, err := DecodeInjectConf([]byte("apiVersion: webhook.d7y.io/v1alpha2\ncliToolsVolumeSizeLimit: 256Mi\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.CliToolsVolumeSizeLimit.String()).To(Equal("256Mi"))
+		})
+
+		It("should reject unknown fields", func() {
+			By("decoding a v1alpha2 config with a legacy field name")
+			_, _, err := DecodeInjectConf([]byte("apiVersion: webhook.d7y.io/v1alpha2\nproxy_port: 8001\n"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("proxy_port"))
+
+			By("decoding a legacy config with a typo")
+			_, _, err = DecodeInjectConf([]byte("enable: true\nproxy_prot: 8001\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid values", func() {
+			_, _, err := DecodeInjectConf([]byte("apiVersion: webhook.d7y.io/v1alpha2\ncliToolsImagePolicy:\n  action: Drop\n"))
+			Expect(err).To(MatchError(ContainSubstring("invalid cli tools image policy action")))
+		})
+
+		It("should reject unsupported versions", func() {
+			_, _, err := DecodeInjectConf([]byte("apiVersion: webhook.d7y.io/v9\n"))
+			Expect(err).To(MatchError(ContainSubstring("unsupported config apiVersion")))
+		})
+	})
+
+	Describe("ConfigManager", func() {
+		It("should expose the schema version of the loaded config", func() {
+			tempDir := GinkgoT().TempDir()
+			configPath := filepath.Join(tempDir, "config.yaml")
+
+			By("loading a legacy config")
+			Expect(os.WriteFile(configPath, []byte("enable: true\nproxy_port: 8001\n"), 0644)).To(Succeed())
+			configManager := NewConfigManager(tempDir)
+			Expect(configManager.APIVersion()).To(Equal(ConfigAPIVersionV1Alpha1))
+			Expect(configManager.GetConfig().ProxyPort).To(Equal(8001))
+			Expect(configManager.GetConfig().APIVersion).To(Equal(ConfigAPIVersion))
+
+			By("reloading a v1alpha2 config")
+			Expect(os.WriteFile(configPath, []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPort: 8002\n"), 0644)).To(Succeed())
+			configManager.reload()
+			Expect(configManager.APIVersion()).To(Equal(ConfigAPIVersionV1Alpha2))
+			Expect(configManager.GetConfig().ProxyPort).To(Equal(8002))
+		})
+
+		It("should report the active version when falling back to defaults", func() {
+			configManager := NewConfigManager(GinkgoT().TempDir())
+			Expect(configManager.APIVersion()).To(Equal(ConfigAPIVersion))
+			Expect(configManager.GetConfig().APIVersion).To(Equal(ConfigAPIVersion))
+		})
+	})
+})
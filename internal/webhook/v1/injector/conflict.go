@@ -0,0 +1,236 @@
+package injector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// Policies for items of the pod that differ from the items injected under the same name
+	ConflictPolicyPreserve string = "Preserve" // the pod keeps its item
+	ConflictPolicyOverride string = "Override" // the injected item replaces the one of the pod
+	ConflictPolicyReject   string = "Reject"   // the pod is rejected
+
+	// Resources with a conflict policy, as listed in the ConflictPolicyAnnotation
+	ConflictResourceEnv           string = "env"
+	ConflictResourceVolume        string = "volume" // volumes and volume mounts
+	ConflictResourceInitContainer string = "initContainer"
+)
+
+// ConflictPolicy is the policy of each resource, ConflictPolicyPreserve when unset.
+type ConflictPolicy struct {
+	Env           string `yaml:"env,omitempty" json:"env,omitempty"`
+	Volume        string `yaml:"volume,omitempty" json:"volume,omitempty"`
+	InitContainer string `yaml:"initContainer,omitempty" json:"initContainer,omitempty"`
+}
+
+func (p *ConflictPolicy) validate() error {
+	for _, policy := range []string{p.Env, p.Volume, p.InitContainer} {
+		if policy != "" && !validConflictPolicy(policy) {
+			return fmt.Errorf("invalid conflict policy %q", policy)
+		}
+	}
+	return nil
+}
+
+func validConflictPolicy(policy string) bool {
+	return policy == ConflictPolicyPreserve || policy == ConflictPolicyOverride || policy == ConflictPolicyReject
+}
+
+// policy returns the policy of the resource, the policy may be nil.
+func (p *ConflictPolicy) policy(resource string) string {
+	var policy string
+	if p != nil {
+		switch resource {
+		case ConflictResourceEnv:
+			policy = p.Env
+		case ConflictResourceVolume:
+			policy = p.Volume
+		case ConflictResourceInitContainer:
+			policy = p.InitContainer
+		}
+	}
+	if policy == "" {
+		return ConflictPolicyPreserve
+	}
+	return policy
+}
+
+// set sets the policy of the resource, and reports whether the resource is known.
+func (p *ConflictPolicy) set(resource string, policy string) bool {
+	switch resource {
+	case ConflictResourceEnv:
+		p.Env = policy
+	case ConflictResourceVolume:
+		p.Volume = policy
+	case ConflictResourceInitContainer:
+		p.InitContainer = policy
+	default:
+		return false
+	}
+	return true
+}
+
+// conflictPolicyForPod returns the policies of the config overridden by the ConflictPolicyAnnotation, and a
+// warning about invalid annotation entries. The annotation lists policies of resources as "resource=policy",
+// or a single policy of all resources. It reports false if the pod has no valid override.
+func conflictPolicyForPod(config *InjectConf, pod *corev1.Pod) (*ConflictPolicy, bool, string) {
+	value, ok := pod.GetAnnotations()[ConflictPolicyAnnotation]
+	if !ok {
+		return config.ConflictPolicy, false, ""
+	}
+	policies := ConflictPolicy{}
+	if config.ConflictPolicy != nil {
+		policies = *config.ConflictPolicy
+	}
+	overridden := false
+	var invalid []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		resource, policy, named := strings.Cut(entry, "=")
+		if !named {
+			policy = resource
+		}
+		if !validConflictPolicy(policy) || named && !policies.set(resource, policy) {
+			invalid = append(invalid, entry)
+			continue
+		}
+		if !named {
+			policies = ConflictPolicy{Env: policy, Volume: policy, InitContainer: policy}
+		}
+		overridden = true
+	}
+	warning := ""
+	if len(invalid) != 0 {
+		warning = fmt.Sprintf("ignore invalid entries of annotation %s: %s", ConflictPolicyAnnotation, strings.Join(invalid, ","))
+	}
+	if !overridden {
+		return config.ConflictPolicy, false, warning
+	}
+	return &policies, true, warning
+}
+
+// Conflict is an item of the pod that differs from the item an injector adds under the same name.
+type Conflict struct {
+	Resource  string
+	Kind      string // e.g. "volume mount" for mounts, under ConflictResourceVolume
+	Name      string
+	Container string // container of env vars and volume mounts
+	Policy    string
+}
+
+func (c Conflict) String() string {
+	if c.Container != "" {
+		return fmt.Sprintf("%s %q of container %q", c.Kind, c.Name, c.Container)
+	}
+	return fmt.Sprintf("%s %q", c.Kind, c.Name)
+}
+
+// resolveConflict records the conflict in the admission, which may be nil, and reports whether the injected
+// item replaces the item of the pod.
+func resolveConflict(config *InjectConf, admission *PodAdmission, conflict Conflict) bool {
+	conflict.Policy = config.ConflictPolicy.policy(conflict.Resource)
+	if admission != nil && !slices.Contains(admission.conflicts, conflict) {
+		admission.conflicts = append(admission.conflicts, conflict)
+	}
+	return conflict.Policy == ConflictPolicyOverride
+}
+
+// CheckConflicts returns warnings about the items of the pod that differ from the ones the injectors
+// added during the admission. It returns an error if the policy of any of them rejects the pod.
+func CheckConflicts(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) ([]string, error) {
+	var warnings []string
+	if _, _, warning := conflictPolicyForPod(config, pod); warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	var rejected []string
+	for _, conflict := range admission.conflicts {
+		switch conflict.Policy {
+		case ConflictPolicyReject:
+			rejected = append(rejected, conflict.String())
+		case ConflictPolicyOverride:
+			warnings = append(warnings, fmt.Sprintf("%s differs from the injected one, overridden", conflict))
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s differs from the injected one, preserved", conflict))
+		}
+	}
+	if len(rejected) != 0 {
+		return warnings, fmt.Errorf("pod conflicts with the injected %s", strings.Join(rejected, ", "))
+	}
+	return warnings, nil
+}
+
+// injectContainer adds the env vars to the container, resolving the conflicts with its env vars of the same name.
+func injectContainer(config *InjectConf, admission *PodAdmission, c *corev1.Container, envs []corev1.EnvVar) {
+	for _, e := range envs {
+		i := slices.IndexFunc(c.Env, func(ce corev1.EnvVar) bool { return ce.Name == e.Name })
+		if i < 0 {
+			c.Env = append(c.Env, *e.DeepCopy())
+			continue
+		}
+		if equality.Semantic.DeepEqual(c.Env[i], e) {
+			continue
+		}
+		conflict := Conflict{Resource: ConflictResourceEnv, Kind: "env", Name: e.Name, Container: c.Name}
+		if resolveConflict(config, admission, conflict) {
+			c.Env[i] = *e.DeepCopy()
+		}
+	}
+}
+
+// addVolume adds the volume to the pod, resolving the conflict with its volume of the same name.
+func addVolume(config *InjectConf, admission *PodAdmission, pod *corev1.Pod, volume corev1.Volume) {
+	i := slices.IndexFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == volume.Name })
+	if i < 0 {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+		return
+	}
+	if equality.Semantic.DeepEqual(pod.Spec.Volumes[i], volume) {
+		return
+	}
+	if resolveConflict(config, admission, Conflict{Resource: ConflictResourceVolume, Kind: "volume", Name: volume.Name}) {
+		pod.Spec.Volumes[i] = volume
+	}
+}
+
+// addVolumeMount adds the mount to the container, resolving the conflict with its mount of the same volume
+// or at the same path.
+func addVolumeMount(config *InjectConf, admission *PodAdmission, c *corev1.Container, mount corev1.VolumeMount) {
+	i := slices.IndexFunc(c.VolumeMounts, func(m corev1.VolumeMount) bool {
+		return m.Name == mount.Name || m.MountPath == mount.MountPath
+	})
+	if i < 0 {
+		c.VolumeMounts = append(c.VolumeMounts, mount)
+		return
+	}
+	if equality.Semantic.DeepEqual(c.VolumeMounts[i], mount) {
+		return
+	}
+	conflict := Conflict{Resource: ConflictResourceVolume, Kind: "volume mount", Name: mount.Name, Container: c.Name}
+	if resolveConflict(config, admission, conflict) {
+		c.VolumeMounts[i] = mount
+	}
+}
+
+// addInitContainer adds the initContainer to the pod, resolving the conflict with its initContainer of the same name.
+func addInitContainer(config *InjectConf, admission *PodAdmission, pod *corev1.Pod, container corev1.Container) {
+	i := slices.IndexFunc(pod.Spec.InitContainers, func(c corev1.Container) bool { return c.Name == container.Name })
+	if i < 0 {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+		return
+	}
+	if equality.Semantic.DeepEqual(pod.Spec.InitContainers[i], container) {
+		return
+	}
+	if resolveConflict(config, admission, Conflict{Resource: ConflictResourceInitContainer, Kind: "initContainer", Name: container.Name}) {
+		pod.Spec.InitContainers[i] = container
+	}
+}
@@ -0,0 +1,143 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ConflictPolicy", func() {
+	var (
+		config *InjectConf
+		pod    *corev1.Pod
+	)
+
+	inject := func(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
+		NewProxyEnvInjector().Inject(pod, config, admission)
+		NewUnixSocketInjector().Inject(pod, config, admission)
+		NewToolsInitcontainerInjector().Inject(pod, config, admission)
+	}
+	// check injects a copy of the pod and checks the conflicts found while injecting it
+	check := func(pod *corev1.Pod, config *InjectConf) ([]string, error) {
+		var admission PodAdmission
+		inject(pod.DeepCopy(), config, &admission)
+		return CheckConflicts(pod, config, &admission)
+	}
+	staleSocket := corev1.Volume{
+		Name:         DfdaemonUnixSockVolumeName,
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/run/other.sock"}},
+	}
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.compile()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{staleSocket},
+				Containers: []corev1.Container{{
+					Name: "app",
+					Env:  []corev1.EnvVar{{Name: ProxyPortEnvName, Value: "8001"}},
+				}},
+			},
+		}
+	})
+
+	It("should preserve the conflicting items with warnings by default", func() {
+		warnings, err := check(pod, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ConsistOf(
+			`env "DRAGONFLY_PROXY_PORT" of container "app" differs from the injected one, preserved`,
+			`volume "dfdaemon-unix-sock" differs from the injected one, preserved`,
+		))
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(BeEmpty(), "the check must not modify the pod")
+
+		inject(pod, config, nil)
+		Expect(pod.Spec.Containers[0].Env[0]).To(Equal(corev1.EnvVar{Name: ProxyPortEnvName, Value: "8001"}))
+		Expect(pod.Spec.Volumes[0]).To(Equal(staleSocket))
+	})
+
+	It("should override the conflicting items", func() {
+		config.ConflictPolicy = &ConflictPolicy{Env: ConflictPolicyOverride, Volume: ConflictPolicyOverride}
+		warnings, err := check(pod, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ContainElement(`env "DRAGONFLY_PROXY_PORT" of container "app" differs from the injected one, overridden`))
+
+		inject(pod, config, nil)
+		Expect(pod.Spec.Containers[0].Env[0]).To(Equal(corev1.EnvVar{Name: ProxyPortEnvName, Value: "4001"}))
+		Expect(pod.Spec.Volumes[0].HostPath.Path).To(Equal(DfdaemonUnixSockPath))
+	})
+
+	It("should reject the pod", func() {
+		config.ConflictPolicy = &ConflictPolicy{Volume: ConflictPolicyReject}
+		warnings, err := check(pod, config)
+		Expect(err).To(MatchError(`pod conflicts with the injected volume "dfdaemon-unix-sock"`))
+		Expect(warnings).To(ConsistOf(`env "DRAGONFLY_PROXY_PORT" of container "app" differs from the injected one, preserved`))
+	})
+
+	It("should not report items identical to the injected ones", func() {
+		config.ConflictPolicy = &ConflictPolicy{Env: ConflictPolicyReject, Volume: ConflictPolicyReject, InitContainer: ConflictPolicyReject}
+		pod.Spec.Volumes = nil
+		pod.Spec.Containers[0].Env = nil
+		inject(pod, config, nil)
+
+		warnings, err := check(pod, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+	})
+
+	It("should report initContainers and volume mounts", func() {
+		config.ConflictPolicy = &ConflictPolicy{InitContainer: ConflictPolicyOverride}
+		pod.Spec.Volumes = nil
+		pod.Spec.Containers[0].Env = nil
+		pod.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "sock", MountPath: DfdaemonUnixSockPath}}
+		pod.Spec.InitContainers = []corev1.Container{{Name: CliToolsInitContainerName, Image: "busybox"}}
+
+		warnings, err := check(pod, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ConsistOf(
+			`volume mount "dfdaemon-unix-sock" of container "app" differs from the injected one, preserved`,
+			`initContainer "d7y-cli-tools" differs from the injected one, overridden`,
+		))
+
+		inject(pod, config, nil)
+		Expect(pod.Spec.InitContainers).To(HaveLen(1))
+		Expect(pod.Spec.InitContainers[0].Image).To(Equal(CliToolsImage))
+	})
+
+	Context("with the pod annotation", func() {
+		It("should override the policy of the resources", func() {
+			config.ConflictPolicy = &ConflictPolicy{Volume: ConflictPolicyReject}
+			pod.Annotations = map[string]string{ConflictPolicyAnnotation: "env=Override, volume=Preserve"}
+
+			effective := EffectiveConfig(config, pod, nil)
+			Expect(effective.ConflictPolicy).To(Equal(&ConflictPolicy{Env: ConflictPolicyOverride, Volume: ConflictPolicyPreserve}))
+			Expect(config.ConflictPolicy).To(Equal(&ConflictPolicy{Volume: ConflictPolicyReject}))
+			_, err := check(pod, effective)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set a single policy of all resources", func() {
+			pod.Annotations = map[string]string{ConflictPolicyAnnotation: ConflictPolicyReject}
+			effective := EffectiveConfig(config, pod, nil)
+			Expect(effective.ConflictPolicy).To(Equal(&ConflictPolicy{
+				Env: ConflictPolicyReject, Volume: ConflictPolicyReject, InitContainer: ConflictPolicyReject,
+			}))
+		})
+
+		It("should warn about invalid entries", func() {
+			pod.Annotations = map[string]string{ConflictPolicyAnnotation: "env=Replace,secret=Reject"}
+			Expect(EffectiveConfig(config, pod, nil)).To(BeIdenticalTo(config))
+			warnings, err := check(pod, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ContainElement(
+				"ignore invalid entries of annotation " + ConflictPolicyAnnotation + ": env=Replace,secret=Reject"))
+		})
+	})
+
+	It("should validate the policies", func() {
+		Expect((&ConflictPolicy{Env: ConflictPolicyOverride}).validate()).To(Succeed())
+		Expect((&ConflictPolicy{Volume: "Replace"}).validate()).To(MatchError(ContainSubstring("invalid conflict policy")))
+	})
+})
@@ -0,0 +1,138 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultDfdaemonVersionLabel is the DaemonSet label holding the dfdaemon version, as set by the Dragonfly helm chart.
+const DefaultDfdaemonVersionLabel string = "app.kubernetes.io/version"
+
+// DfdaemonCompatibility checks the cli tools image against the version of the running dfdaemon.
+type DfdaemonCompatibility struct {
+	// Namespace and name of the dfdaemon DaemonSet
+	DaemonSetNamespace string `yaml:"daemonSetNamespace" json:"daemonSetNamespace"`
+	DaemonSetName      string `yaml:"daemonSetName" json:"daemonSetName"`
+	// Container running dfdaemon, whose image tag is the version if the DaemonSet has no version label,
+	// the first container when unset
+	Container string `yaml:"container,omitempty" json:"container,omitempty"`
+	// DaemonSet label holding the dfdaemon version, DefaultDfdaemonVersionLabel when unset
+	VersionLabel string `yaml:"versionLabel,omitempty" json:"versionLabel,omitempty"`
+	// Whether to replace the tag of incompatible cli tools images with the CliToolsTag of the dfdaemon
+	// version, instead of only warning
+	Strict bool `yaml:"strict,omitempty" json:"strict,omitempty"`
+	// Compatibility table, the first entry matching the dfdaemon version is used
+	Versions []DfdaemonCompatibleVersions `yaml:"versions" json:"versions"`
+}
+
+// DfdaemonCompatibleVersions lists the cli tools versions compatible with dfdaemon versions. Versions are
+// prefixes matching whole components, with or without a leading "v", e.g. "v2.1" matches "2.1.0" and "v2.1.3-rc.1".
+type DfdaemonCompatibleVersions struct {
+	Dfdaemon string   `yaml:"dfdaemon" json:"dfdaemon"`
+	CliTools []string `yaml:"cliTools" json:"cliTools"`
+	// Tag of the cli tools image used in strict mode
+	CliToolsTag string `yaml:"cliToolsTag,omitempty" json:"cliToolsTag,omitempty"`
+}
+
+func (c *DfdaemonCompatibility) validate() error {
+	if c.DaemonSetNamespace == "" || c.DaemonSetName == "" {
+		return errors.New("dfdaemon compatibility requires daemonSetNamespace and daemonSetName")
+	}
+	for _, v := range c.Versions {
+		if v.Dfdaemon == "" || len(v.CliTools) == 0 {
+			return fmt.Errorf("dfdaemon compatibility entry %q requires dfdaemon and cliTools versions", v.Dfdaemon)
+		}
+		if v.CliToolsTag != "" && !imageTagRegexp.MatchString(v.CliToolsTag) {
+			return fmt.Errorf("invalid cli tools tag %q for dfdaemon %q", v.CliToolsTag, v.Dfdaemon)
+		}
+	}
+	return nil
+}
+
+// DfdaemonVersion returns the dfdaemon version of the DaemonSet, from its version label, or else from
+// the image tag of the dfdaemon container. It returns an empty string if neither is set.
+func DfdaemonVersion(ds *appsv1.DaemonSet, compat *DfdaemonCompatibility) string {
+	label := compat.VersionLabel
+	if label == "" {
+		label = DefaultDfdaemonVersionLabel
+	}
+	if version := ds.Labels[label]; version != "" {
+		return version
+	}
+
+	containers := ds.Spec.Template.Spec.Containers
+	i := slices.IndexFunc(containers, func(c corev1.Container) bool {
+		return compat.Container == "" || c.Name == compat.Container
+	})
+	if i < 0 {
+		return ""
+	}
+	ref, err := parseImageReference(containers[i].Image)
+	if err != nil {
+		return ""
+	}
+	return ref.Tag
+}
+
+// versionMatches reports whether the version starts with the prefix, at a component boundary.
+func versionMatches(version string, prefix string) bool {
+	version, prefix = strings.TrimPrefix(version, "v"), strings.TrimPrefix(prefix, "v")
+	rest, ok := strings.CutPrefix(version, prefix)
+	return ok && (rest == "" || rest[0] == '.' || rest[0] == '-' || rest[0] == '+')
+}
+
+// CheckDfdaemonCompatibility checks the cli tools image of the pod against the compatibility table for
+// the dfdaemon version. In strict mode an incompatible image gets the compatible tag, which the admission
+// injects, and the returned config copies it with an initContainer if the node cache holds another version;
+// otherwise the config is returned unchanged. The warning explains any mismatch.
+func CheckDfdaemonCompatibility(
+	config *InjectConf, admission *PodAdmission, pod *corev1.Pod, dfdaemonVersion string,
+) (*InjectConf, string) {
+	compat := config.DfdaemonCompatibility
+	if compat == nil || dfdaemonVersion == "" {
+		return config, ""
+	}
+	i := slices.IndexFunc(compat.Versions, func(v DfdaemonCompatibleVersions) bool {
+		return versionMatches(dfdaemonVersion, v.Dfdaemon)
+	})
+	if i < 0 {
+		return config, fmt.Sprintf("dfdaemon version %q has no entry in the cli tools compatibility table", dfdaemonVersion)
+	}
+	entry := compat.Versions[i]
+
+	image := CliToolsImageForPod(config, admission, pod)
+	ref, err := parseImageReference(image)
+	if err != nil || ref.Tag == "" && ref.Digest != "" {
+		return config, "" // the version of images without a tag is unknown
+	}
+	tag := ref.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	if slices.ContainsFunc(entry.CliTools, func(prefix string) bool { return versionMatches(tag, prefix) }) {
+		return config, ""
+	}
+
+	if !compat.Strict || entry.CliToolsTag == "" {
+		return config, fmt.Sprintf("cli tools image %q may be incompatible with dfdaemon %s, compatible versions are %v",
+			image, dfdaemonVersion, entry.CliTools)
+	}
+	ref.Tag, ref.Digest = entry.CliToolsTag, ""
+	admission.cliToolsImage = ref.String()
+	warning := fmt.Sprintf("cli tools image %q is incompatible with dfdaemon %s, using %q",
+		image, dfdaemonVersion, admission.cliToolsImage)
+	if config.CliToolsDeliveryMode != CliToolsDeliveryModeHostPath ||
+		cliToolsCacheKey(admission.cliToolsImage) == cliToolsCacheKey(config.CliToolsImage) {
+		return config, warning
+	}
+	podlog.Info("copy cli tools with an initContainer, the node cache only holds the configured cli tools version",
+		"pod", pod.Name, "namespace", pod.Namespace, "image", admission.cliToolsImage)
+	compatible := *config
+	compatible.CliToolsDeliveryMode = CliToolsDeliveryModeInitContainer
+	return &compatible, warning
+}
@@ -0,0 +1,158 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Dfdaemon compatibility", func() {
+	var (
+		config    *InjectConf
+		admission *PodAdmission
+		pod       *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		config = &InjectConf{
+			CliToolsDirPath: CliToolsDirPath,
+			CliToolsImage:   "dragonflyoss/cli-tools:v2.0.1",
+			DfdaemonCompatibility: &DfdaemonCompatibility{
+				DaemonSetNamespace: "dragonfly-system",
+				DaemonSetName:      "dragonfly-dfdaemon",
+				Versions: []DfdaemonCompatibleVersions{
+					{Dfdaemon: "v2.1", CliTools: []string{"v2.1", "v2.2"}, CliToolsTag: "v2.1.0"},
+					{Dfdaemon: "v2", CliTools: []string{"v2.0"}},
+				},
+			},
+		}
+		admission = &PodAdmission{}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+	})
+
+	DescribeTable("matching versions at component boundaries",
+		func(version, prefix string, matches bool) {
+			Expect(versionMatches(version, prefix)).To(Equal(matches))
+		},
+		Entry("equal", "v2.1.0", "v2.1.0", true),
+		Entry("minor prefix", "v2.1.3", "v2.1", true),
+		Entry("without v", "2.1.3", "v2.1", true),
+		Entry("pre-release", "v2.1.0-rc.1", "v2.1.0", true),
+		Entry("partial component", "v2.10.0", "v2.1", false),
+		Entry("other version", "v2.0.1", "v2.1", false),
+	)
+
+	Context("DfdaemonVersion", func() {
+		var ds *appsv1.DaemonSet
+
+		BeforeEach(func() {
+			ds = &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "dfdaemon", Image: "dragonflyoss/client:v2.1.3"},
+					{Name: "exporter", Image: "exporter:v1.0.0"},
+				},
+			}}}}
+		})
+
+		It("should prefer the version label", func() {
+			ds.Labels = map[string]string{DefaultDfdaemonVersionLabel: "v2.1.4"}
+			Expect(DfdaemonVersion(ds, config.DfdaemonCompatibility)).To(Equal("v2.1.4"))
+		})
+
+		It("should use the image tag of the dfdaemon container", func() {
+			Expect(DfdaemonVersion(ds, config.DfdaemonCompatibility)).To(Equal("v2.1.3"))
+
+			config.DfdaemonCompatibility.Container = "exporter"
+			Expect(DfdaemonVersion(ds, config.DfdaemonCompatibility)).To(Equal("v1.0.0"))
+
+			config.DfdaemonCompatibility.Container = "missing"
+			Expect(DfdaemonVersion(ds, config.DfdaemonCompatibility)).To(BeEmpty())
+		})
+	})
+
+	It("should accept compatible images without a warning", func() {
+		checked, warning := CheckDfdaemonCompatibility(config, admission, pod, "v2.0.5")
+		Expect(warning).To(BeEmpty())
+		Expect(checked).To(BeIdenticalTo(config))
+		Expect(admission.cliToolsImage).To(BeEmpty())
+	})
+
+	It("should skip the check when the dfdaemon version is unknown", func() {
+		checked, warning := CheckDfdaemonCompatibility(config, admission, pod, "")
+		Expect(warning).To(BeEmpty())
+		Expect(checked).To(BeIdenticalTo(config))
+	})
+
+	It("should warn about dfdaemon versions missing from the table", func() {
+		_, warning := CheckDfdaemonCompatibility(config, admission, pod, "v3.0.0")
+		Expect(warning).To(ContainSubstring("has no entry in the cli tools compatibility table"))
+	})
+
+	It("should warn about incompatible images", func() {
+		checked, warning := CheckDfdaemonCompatibility(config, admission, pod, "v2.1.3")
+		Expect(warning).To(ContainSubstring("may be incompatible with dfdaemon v2.1.3"))
+		Expect(checked).To(BeIdenticalTo(config))
+	})
+
+	Context("in strict mode", func() {
+		BeforeEach(func() {
+			config.DfdaemonCompatibility.Strict = true
+		})
+
+		It("should inject the compatible tag", func() {
+			checked, warning := CheckDfdaemonCompatibility(config, admission, pod, "v2.1.3")
+			Expect(warning).To(ContainSubstring(`using "docker.io/dragonflyoss/cli-tools:v2.1.0"`))
+			Expect(CliToolsImageForPod(checked, admission, pod)).To(Equal("docker.io/dragonflyoss/cli-tools:v2.1.0"))
+			Expect(CliToolsImageForPod(config, nil, pod)).To(Equal(config.CliToolsImage))
+
+			By("injecting the compatible image")
+			NewToolsInitcontainerInjector().Inject(pod, checked, admission)
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal("docker.io/dragonflyoss/cli-tools:v2.1.0"))
+		})
+
+		It("should replace the tag of annotated images and drop their digest", func() {
+			pod.Annotations = map[string]string{
+				CliToolsImageAnnotation: "dragonflyoss/cli-tools:v1.0.0@" + manifestDigest("v1"),
+			}
+			checked, _ := CheckDfdaemonCompatibility(config, admission, pod, "v2.1.3")
+			Expect(CliToolsImageForPod(checked, admission, pod)).To(Equal("docker.io/dragonflyoss/cli-tools:v2.1.0"))
+		})
+
+		It("should copy the compatible tag missing from the node cache with an initContainer", func() {
+			config.CliToolsDeliveryMode = CliToolsDeliveryModeHostPath
+			checked, _ := CheckDfdaemonCompatibility(config, admission, pod, "v2.1.3")
+			Expect(checked.CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeInitContainer))
+			Expect(config.CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeHostPath))
+
+			By("injecting the compatible image")
+			NewToolsInitcontainerInjector().Inject(pod, checked, admission)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal("docker.io/dragonflyoss/cli-tools:v2.1.0"))
+			Expect(pod.Spec.Volumes).To(HaveLen(1))
+			Expect(pod.Spec.Volumes[0].HostPath).To(BeNil())
+		})
+
+		It("should only warn without a compatible tag", func() {
+			config.CliToolsImage = "dragonflyoss/cli-tools:v1.0.0"
+			checked, warning := CheckDfdaemonCompatibility(config, admission, pod, "v2.0.0")
+			Expect(warning).To(ContainSubstring("may be incompatible"))
+			Expect(checked).To(BeIdenticalTo(config))
+		})
+	})
+
+	It("should validate the compatibility table", func() {
+		Expect(config.validate()).To(Succeed())
+
+		config.DfdaemonCompatibility.Versions[0].CliToolsTag = "v2.1.0:bad"
+		Expect(config.validate()).To(MatchError(ContainSubstring("invalid cli tools tag")))
+
+		config.DfdaemonCompatibility.Versions[0].CliToolsTag = ""
+		config.DfdaemonCompatibility.Versions[1].CliTools = nil
+		Expect(config.validate()).To(MatchError(ContainSubstring("requires dfdaemon and cliTools versions")))
+
+		config.DfdaemonCompatibility.DaemonSetName = ""
+		Expect(config.validate()).To(MatchError(ContainSubstring("requires daemonSetNamespace and daemonSetName")))
+	})
+})
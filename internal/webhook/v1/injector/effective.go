@@ -0,0 +1,217 @@
+package injector
+
+import (
+	"slices"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// NamespaceInfo is the state of the pod's namespace, and of the cluster, that the effective config depends on.
+type NamespaceInfo struct {
+	Namespace   *corev1.Namespace
+	LimitRanges []corev1.LimitRange
+	// Version of the API server, only needed by CliToolsDeliveryModeAuto
+	ServerVersion *version.Info
+	// Node the pod is bound to at creation, only needed by ProxyHostHostIP
+	Node *corev1.Node
+	// Family of status.hostIP of the cluster nodes, see NodeHostIPFamily, only needed by ProxyHostHostIP
+	// for pods not bound to a node at creation
+	HostIPFamily corev1.IPFamily
+}
+
+// EffectiveConfig returns the config the injectors apply to the pod: the loaded config merged
+// with the namespace defaults and the pod's annotation overrides. The loaded config is shared
+// and is never modified, the overrides replace fields of a shallow copy instead. nsInfo may be nil.
+func EffectiveConfig(config *InjectConf, pod *corev1.Pod, nsInfo *NamespaceInfo) *InjectConf {
+	effective := *config
+	overridden := false
+
+	if image, _, err := cliToolsImageForPod(config, pod); err == nil && image != config.CliToolsImage {
+		effective.CliToolsImage = image
+		overridden = true
+	}
+
+	if auth, ok := proxyAuthForNamespace(config, nsInfo); ok {
+		effective.ProxyAuth = auth
+		overridden = true
+	}
+
+	if policy, ok, _ := conflictPolicyForPod(config, pod); ok {
+		effective.ConflictPolicy = policy
+		overridden = true
+	}
+
+	if pod.Spec.HostNetwork && config.HostNetwork != nil {
+		config.HostNetwork.apply(&effective)
+		overridden = true
+	}
+
+	if config.CliToolsDeliveryMode == CliToolsDeliveryModeAuto {
+		effective.CliToolsDeliveryMode = resolveCliToolsDeliveryMode(config, nsInfo)
+		overridden = true
+	}
+
+	if tools, _ := cliToolsForPod(config, pod); !slices.Equal(tools, config.CliTools) {
+		effective.CliTools = tools
+		overridden = true
+	}
+
+	if value, ok := pod.GetAnnotations()[CliToolsAddToPathAnnotation]; ok {
+		addToPath, err := strconv.ParseBool(value)
+		if err != nil {
+			podlog.Error(err, "ignore invalid cli tools PATH annotation", "annotation", CliToolsAddToPathAnnotation, "pod", pod.Name)
+		} else if addToPath != config.CliToolsAddToPath {
+			effective.CliToolsAddToPath = addToPath
+			overridden = true
+		}
+	}
+
+	annotations := pod.GetAnnotations()
+	if value, ok := annotations[CliToolsVolumeMediumAnnotation]; ok {
+		if medium := corev1.StorageMedium(value); !validCliToolsVolumeMedium(medium) {
+			podlog.Info("ignore invalid cli tools volume medium annotation", "value", value, "pod", pod.Name)
+		} else if medium != config.CliToolsVolumeMedium {
+			effective.CliToolsVolumeMedium = medium
+			overridden = true
+		}
+	}
+	if value, ok := annotations[CliToolsVolumeSizeLimitAnnotation]; ok {
+		sizeLimit, err := resource.ParseQuantity(value)
+		if err != nil {
+			podlog.Error(err, "ignore invalid cli tools volume size limit annotation",
+				"annotation", CliToolsVolumeSizeLimitAnnotation, "pod", pod.Name)
+		} else {
+			effective.CliToolsVolumeSizeLimit = &sizeLimit
+			overridden = true
+		}
+	}
+
+	if resources, ok := effectiveCliToolsResources(config, pod, nsInfo); ok {
+		effective.CliToolsResources = resources
+		overridden = true
+	}
+
+	level := namespacePodSecurityLevel(config, nsInfo)
+	if sc, changed := podSecurityCompliant(config.cliToolsSecurityContext(), level); changed {
+		podlog.Info("adjust cli tools security context to the namespace pod security level",
+			"pod", pod.Name, "namespace", pod.Namespace, "level", level)
+		effective.CliToolsSecurityContext = sc
+		overridden = true
+	}
+	if level != PodSecurityLevelPrivileged && !config.DisableUnixSocket {
+		podlog.Info("skip dfdaemon unix socket, hostPath volumes are forbidden by the namespace pod security level",
+			"pod", pod.Name, "namespace", pod.Namespace, "level", level)
+		effective.DisableUnixSocket = true
+		overridden = true
+	}
+	if level != PodSecurityLevelPrivileged && config.CliToolsDeliveryMode == CliToolsDeliveryModeHostPath {
+		podlog.Info("copy cli tools with an initContainer, hostPath volumes are forbidden by the namespace pod security level",
+			"pod", pod.Name, "namespace", pod.Namespace, "level", level)
+		effective.CliToolsDeliveryMode = CliToolsDeliveryModeInitContainer
+		overridden = true
+	}
+	if effective.CliToolsDeliveryMode == CliToolsDeliveryModeHostPath &&
+		cliToolsCacheKey(effective.CliToolsImage) != cliToolsCacheKey(config.CliToolsImage) {
+		podlog.Info("copy cli tools with an initContainer, the node cache only holds the configured cli tools version",
+			"pod", pod.Name, "namespace", pod.Namespace, "image", effective.CliToolsImage)
+		effective.CliToolsDeliveryMode = CliToolsDeliveryModeInitContainer
+		overridden = true
+	}
+
+	if !overridden {
+		return config
+	}
+	effective.compiled = config.compiled.withOverrides(&effective)
+	return &effective
+}
+
+// effectiveCliToolsResources merges the cli tools resources, in order of precedence, from the pod
+// annotations, the config and the namespace LimitRange defaults. It reports false if the result is
+// identical to the configured resources.
+func effectiveCliToolsResources(
+	config *InjectConf, pod *corev1.Pod, nsInfo *NamespaceInfo,
+) (corev1.ResourceRequirements, bool) {
+	overridden := false
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+
+	if config.CliToolsResourcesFromLimitRange && nsInfo != nil {
+		for _, lr := range nsInfo.LimitRanges {
+			for _, item := range lr.Spec.Limits {
+				if item.Type != corev1.LimitTypeContainer {
+					continue
+				}
+				overridden = mergeMissing(resources.Limits, item.Default) || overridden
+				overridden = mergeMissing(resources.Requests, item.DefaultRequest) || overridden
+				// Like the LimitRanger admission plugin, a default limit also defaults the request.
+				overridden = mergeMissing(resources.Requests, item.Default) || overridden
+			}
+		}
+	}
+
+	for name, quantity := range config.CliToolsResources.Requests {
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range config.CliToolsResources.Limits {
+		resources.Limits[name] = quantity
+	}
+
+	annotationResources := []struct {
+		annotation string
+		list       corev1.ResourceList
+		name       corev1.ResourceName
+	}{
+		{CliToolsCPURequestAnnotation, resources.Requests, corev1.ResourceCPU},
+		{CliToolsCPULimitAnnotation, resources.Limits, corev1.ResourceCPU},
+		{CliToolsMemoryRequestAnnotation, resources.Requests, corev1.ResourceMemory},
+		{CliToolsMemoryLimitAnnotation, resources.Limits, corev1.ResourceMemory},
+	}
+	annotations := pod.GetAnnotations()
+	for _, ar := range annotationResources {
+		value, ok := annotations[ar.annotation]
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			podlog.Error(err, "ignore invalid cli tools resource annotation", "annotation", ar.annotation, "pod", pod.Name)
+			continue
+		}
+		ar.list[ar.name] = quantity
+		overridden = true
+	}
+
+	if !overridden {
+		return config.CliToolsResources, false
+	}
+	if len(resources.Requests) == 0 {
+		resources.Requests = nil
+	}
+	if len(resources.Limits) == 0 {
+		resources.Limits = nil
+	}
+	// A request above the limit is rejected by the API server, cap it like the limit intends.
+	for name, limit := range resources.Limits {
+		if request, ok := resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+			resources.Requests[name] = limit
+		}
+	}
+	return resources, true
+}
+
+// mergeMissing copies the quantities of src that are missing from dst, and reports whether any were copied.
+func mergeMissing(dst, src corev1.ResourceList) bool {
+	merged := false
+	for name, quantity := range src {
+		if _, ok := dst[name]; !ok {
+			dst[name] = quantity
+			merged = true
+		}
+	}
+	return merged
+}
@@ -0,0 +1,151 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("EffectiveConfig", func() {
+	var config *InjectConf
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.compile()
+	})
+
+	It("should return the loaded config when the pod has no overrides", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+		Expect(EffectiveConfig(config, pod, nil)).To(BeIdenticalTo(config))
+	})
+
+	It("should apply the cli tools image annotation without modifying the loaded config", func() {
+		By("creating a pod with the cli tools image annotation")
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Annotations: map[string]string{CliToolsImageAnnotation: "annotated/tools:v1"},
+		}}
+
+		By("merging the config")
+		effective := EffectiveConfig(config, pod, nil)
+
+		By("verifying the override is applied to a copy")
+		Expect(effective.CliToolsImage).To(Equal("annotated/tools:v1"))
+		Expect(effective.ProxyPort).To(Equal(config.ProxyPort))
+		Expect(config.CliToolsImage).To(Equal(CliToolsImage))
+		Expect(config.compiled).NotTo(BeNil())
+	})
+
+	It("should keep the compiled image rules and recompute the proxy env vars of the pod", func() {
+		config.ImageRewrites = map[string]string{"docker.io": "mirror.local"}
+		config.compile()
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Annotations: map[string]string{CliToolsImageAnnotation: "annotated/tools:v1"},
+		}}
+		pod.Spec.HostNetwork = true
+		config.HostNetwork = &HostNetworkProfile{}
+
+		effective := EffectiveConfig(config, pod, nil)
+		Expect(effective.compiled).NotTo(BeIdenticalTo(config.compiled))
+		Expect(effective.compiled.imageRewrites).To(Equal(config.compiled.imageRewrites))
+		Expect(effective.compiled.imagePolicy).To(BeIdenticalTo(config.compiled.imagePolicy))
+		Expect(effective.proxyEnvs(nil)).To(Equal(envsFromConfig(effective, nil)))
+		Expect(effective.proxyEnvs(NewPodAdmission(effective, pod, nil))).NotTo(Equal(config.proxyEnvs(nil)))
+	})
+
+	It("should apply the cli tools PATH annotation", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Annotations: map[string]string{CliToolsAddToPathAnnotation: "true"},
+		}}
+		Expect(EffectiveConfig(config, pod, nil).CliToolsAddToPath).To(BeTrue())
+
+		By("ignoring an invalid value")
+		pod.Annotations[CliToolsAddToPathAnnotation] = "yes please"
+		Expect(EffectiveConfig(config, pod, nil)).To(BeIdenticalTo(config))
+	})
+
+	Context("when merging the cli tools resources", func() {
+		var nsInfo *NamespaceInfo
+
+		BeforeEach(func() {
+			nsInfo = &NamespaceInfo{
+				Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "quota"}},
+				LimitRanges: []corev1.LimitRange{{
+					Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{
+						{
+							Type:    corev1.LimitTypePod,
+							Default: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+						},
+						{
+							Type: corev1.LimitTypeContainer,
+							Default: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("500m"),
+								corev1.ResourceMemory: resource.MustParse("256Mi"),
+							},
+							DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+						},
+					}},
+				}},
+			}
+		})
+
+		It("should ignore the LimitRange unless enabled", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+			Expect(EffectiveConfig(config, pod, nsInfo)).To(BeIdenticalTo(config))
+		})
+
+		It("should fill missing resources from the container LimitRange defaults", func() {
+			By("enabling LimitRange defaults with a configured memory limit")
+			config.CliToolsResourcesFromLimitRange = true
+			config.CliToolsResources = corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+
+			By("verifying the config wins over the LimitRange")
+			effective := EffectiveConfig(config, pod, nsInfo)
+			Expect(effective.CliToolsResources.Limits).To(Equal(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			}))
+			Expect(effective.CliToolsResources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			}))
+		})
+
+		It("should apply the annotation overrides last", func() {
+			By("creating a pod with resource annotations")
+			config.CliToolsResources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name: "test-pod",
+				Annotations: map[string]string{
+					CliToolsCPULimitAnnotation:      "20m",
+					CliToolsMemoryRequestAnnotation: "32Mi",
+					CliToolsMemoryLimitAnnotation:   "not-a-quantity",
+				},
+			}}
+
+			By("verifying the merged resources")
+			effective := EffectiveConfig(config, pod, nil)
+			Expect(effective.CliToolsResources).To(Equal(corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("20m"),
+					corev1.ResourceMemory: resource.MustParse("32Mi"),
+				},
+				Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("20m")},
+			}))
+
+			By("verifying the loaded config is untouched")
+			Expect(config.CliToolsResources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("50m"),
+			}))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package injector
+
+import (
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultHostNetworkProxyHost is the address of the dfdaemon proxy in the network of the node.
+const DefaultHostNetworkProxyHost string = "127.0.0.1"
+
+// HostNetworkProfile is how pods with hostNetwork: true are injected. They share the network of the
+// node, so they reach the node dfdaemon at a local address, whatever the proxy mode.
+type HostNetworkProfile struct {
+	// Whether to skip injecting hostNetwork pods
+	Skip bool `yaml:"skip,omitempty" json:"skip,omitempty"`
+	// IP or hostname of the dfdaemon in the network of the node, DefaultHostNetworkProxyHost when unset
+	ProxyHost string `yaml:"proxyHost,omitempty" json:"proxyHost,omitempty"`
+	// Whether to skip mounting the dfdaemon unix socket
+	DisableUnixSocket bool `yaml:"disableUnixSocket,omitempty" json:"disableUnixSocket,omitempty"`
+	// Whether to skip injecting the cli tools
+	DisableCliTools bool `yaml:"disableCliTools,omitempty" json:"disableCliTools,omitempty"`
+}
+
+func (p *HostNetworkProfile) validate() error {
+	if p.ProxyHost == "" {
+		return nil
+	}
+	if _, err := netip.ParseAddr(p.ProxyHost); err != nil && len(validation.IsDNS1123Subdomain(p.ProxyHost)) != 0 {
+		return fmt.Errorf("host network proxy host %q must be an IP or a hostname", p.ProxyHost)
+	}
+	return nil
+}
+
+// proxyHost returns the URL host of the dfdaemon proxy for hostNetwork pods.
+func (p *HostNetworkProfile) proxyHost() string {
+	if p.ProxyHost == "" {
+		return DefaultHostNetworkProxyHost
+	}
+	if ip, err := netip.ParseAddr(p.ProxyHost); err == nil {
+		return urlHost(ip)
+	}
+	return p.ProxyHost
+}
+
+// apply sets the profile in the effective config of a hostNetwork pod, the proxy host is set in its
+// admission, see NewPodAdmission.
+func (p *HostNetworkProfile) apply(effective *InjectConf) {
+	effective.DisableUnixSocket = effective.DisableUnixSocket || p.DisableUnixSocket
+	effective.DisableCliTools = effective.DisableCliTools || p.DisableCliTools
+}
+
+// SkipHostNetworkPod reports whether the config skips injecting the pod because it uses the network of the node.
+func SkipHostNetworkPod(config *InjectConf, pod *corev1.Pod) bool {
+	return pod.Spec.HostNetwork && config.HostNetwork != nil && config.HostNetwork.Skip
+}
@@ -0,0 +1,98 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("HostNetworkProfile", func() {
+	var (
+		config *InjectConf
+		pod    *corev1.Pod
+	)
+
+	proxyURL := func(config *InjectConf, pod *corev1.Pod) string {
+		effective := EffectiveConfig(config, pod, nil)
+		for _, env := range effective.proxyEnvs(NewPodAdmission(effective, pod, nil)) {
+			if env.Name == ProxyEnvName {
+				return env.Value
+			}
+		}
+		return ""
+	}
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.HostNetwork = &HostNetworkProfile{}
+		config.compile()
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+			Spec: corev1.PodSpec{
+				HostNetwork: true,
+				Containers:  []corev1.Container{{Name: "app"}},
+			},
+		}
+	})
+
+	It("should not change the config of pods in the pod network", func() {
+		pod.Spec.HostNetwork = false
+		Expect(EffectiveConfig(config, pod, nil)).To(BeIdenticalTo(config))
+	})
+
+	It("should reach the proxy at localhost", func() {
+		Expect(proxyURL(config, pod)).To(Equal("http://127.0.0.1:$(" + ProxyPortEnvName + ")"))
+
+		By("ignoring the Service proxy mode")
+		config.ProxyMode = ProxyModeService
+		config.ProxyService = &ProxyService{Namespace: "dragonfly-system", Name: "dfdaemon"}
+		Expect(proxyURL(config, pod)).To(Equal("http://127.0.0.1:$(" + ProxyPortEnvName + ")"))
+	})
+
+	It("should use the configured proxy host", func() {
+		config.HostNetwork.ProxyHost = "::1"
+		Expect(proxyURL(config, pod)).To(Equal("http://[::1]:$(" + ProxyPortEnvName + ")"))
+
+		config.HostNetwork.ProxyHost = "localhost"
+		Expect(proxyURL(config, pod)).To(Equal("http://localhost:$(" + ProxyPortEnvName + ")"))
+	})
+
+	It("should skip the unix socket and the cli tools", func() {
+		By("injecting both by default")
+		effective := EffectiveConfig(config, pod, nil)
+		NewUnixSocketInjector().Inject(pod, effective, nil)
+		NewToolsInitcontainerInjector().Inject(pod, effective, nil)
+		Expect(pod.Spec.Volumes).To(HaveLen(2))
+		Expect(pod.Spec.InitContainers).To(HaveLen(1))
+
+		By("skipping both when disabled by the profile")
+		config.HostNetwork.DisableUnixSocket = true
+		config.HostNetwork.DisableCliTools = true
+		pod.Spec.Volumes, pod.Spec.InitContainers, pod.Spec.Containers = nil, nil, []corev1.Container{{Name: "app"}}
+		effective = EffectiveConfig(config, pod, nil)
+		NewUnixSocketInjector().Inject(pod, effective, nil)
+		NewToolsInitcontainerInjector().Inject(pod, effective, nil)
+		Expect(pod.Spec.Volumes).To(BeEmpty())
+		Expect(pod.Spec.InitContainers).To(BeEmpty())
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+		Expect(config.DisableUnixSocket).To(BeFalse())
+	})
+
+	It("should skip hostNetwork pods when configured", func() {
+		Expect(SkipHostNetworkPod(config, pod)).To(BeFalse())
+		config.HostNetwork.Skip = true
+		Expect(SkipHostNetworkPod(config, pod)).To(BeTrue())
+		pod.Spec.HostNetwork = false
+		Expect(SkipHostNetworkPod(config, pod)).To(BeFalse())
+	})
+
+	It("should validate the proxy host", func() {
+		for _, host := range []string{"", "127.0.0.1", "::1", "localhost", "dfdaemon.local"} {
+			Expect((&HostNetworkProfile{ProxyHost: host}).validate()).To(Succeed())
+		}
+		for _, host := range []string{"127.0.0.1:4001", "http://localhost", "[::1]"} {
+			Expect((&HostNetworkProfile{ProxyHost: host}).validate()).To(MatchError(ContainSubstring("must be an IP or a hostname")))
+		}
+	})
+})
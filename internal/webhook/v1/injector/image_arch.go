@@ -0,0 +1,90 @@
+package injector
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podArchitectures returns the architectures the pod can be scheduled on, from its kubernetes.io/arch
+// nodeSelector and required node affinity. It returns nil if the pod doesn't constrain the architecture.
+func podArchitectures(pod *corev1.Pod) []string {
+	var archs []string
+	constrained := false
+	if arch, ok := pod.Spec.NodeSelector[corev1.LabelArchStable]; ok {
+		archs, constrained = []string{arch}, true
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return archs
+	}
+	// The terms are ORed, the pod is only constrained if every term constrains the architecture.
+	var affinityArchs []string
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		termArchs, ok := nodeSelectorTermArchitectures(term)
+		if !ok {
+			return archs
+		}
+		for _, arch := range termArchs {
+			if !slices.Contains(affinityArchs, arch) {
+				affinityArchs = append(affinityArchs, arch)
+			}
+		}
+	}
+	if !constrained {
+		return affinityArchs
+	}
+	return slices.DeleteFunc(archs, func(arch string) bool { return !slices.Contains(affinityArchs, arch) })
+}
+
+// nodeSelectorTermArchitectures returns the architectures allowed by the In expressions of the term,
+// and false if the term doesn't constrain the architecture that way.
+func nodeSelectorTermArchitectures(term corev1.NodeSelectorTerm) ([]string, bool) {
+	var archs []string
+	constrained := false
+	for _, expr := range term.MatchExpressions {
+		if expr.Key != corev1.LabelArchStable || expr.Operator != corev1.NodeSelectorOpIn {
+			continue
+		}
+		// The expressions are ANDed, intersect them.
+		if !constrained {
+			archs, constrained = slices.Clone(expr.Values), true
+			continue
+		}
+		archs = slices.DeleteFunc(archs, func(arch string) bool { return !slices.Contains(expr.Values, arch) })
+	}
+	return archs, constrained
+}
+
+// cliToolsArchImage returns the image of CliToolsArchImages for the architecture of the pod. The
+// configured image is used if the pod's architecture is ambiguous or has no image, the warning
+// explains why, or an error is returned if CliToolsArchAction rejects ambiguous pods.
+func cliToolsArchImage(config *InjectConf, pod *corev1.Pod) (string, string, error) {
+	if len(config.CliToolsArchImages) == 0 {
+		return config.CliToolsImage, "", nil
+	}
+
+	archs := podArchitectures(pod)
+	var reason string
+	switch len(archs) {
+	case 0:
+		reason = fmt.Sprintf("the pod doesn't select a single %s", corev1.LabelArchStable)
+	case 1:
+		if image, ok := config.CliToolsArchImages[archs[0]]; ok {
+			return image, "", nil
+		}
+		reason = fmt.Sprintf("no cli tools image is configured for architecture %q", archs[0])
+	default:
+		sort.Strings(archs)
+		reason = fmt.Sprintf("the pod can run on architectures %v", archs)
+	}
+
+	if config.CliToolsArchAction == ImagePolicyActionReject {
+		return config.CliToolsImage, "", fmt.Errorf("cannot select the cli tools image: %s", reason)
+	}
+	return config.CliToolsImage, fmt.Sprintf("%s, using cli tools image %q", reason, config.CliToolsImage), nil
+}
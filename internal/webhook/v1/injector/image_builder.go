@@ -0,0 +1,295 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Image builders whose base image pulls are mirrored through the node dfdaemon
+const (
+	ImageBuilderBuildKit string = "buildkit"
+	ImageBuilderKaniko   string = "kaniko"
+	ImageBuilderBuildah  string = "buildah"
+
+	DefaultBuildKitConfigPath string = "/etc/buildkit/buildkitd.toml"
+	DefaultBuildahConfigPath  string = "/etc/containers/registries.conf.d/dragonfly.conf" // Drop-in merged with registries.conf
+	// Image writing the builder configs, the cli tools image may have no shell, see CliToolsInstallModeEntrypoint
+	DefaultImageBuilderConfigImage string = "busybox:stable"
+
+	buildKitConfigFileName string = "buildkitd.toml"
+	buildahConfigFileName  string = "registries.conf"
+	buildKitConfigEnvName  string = "DRAGONFLY_BUILDKITD_TOML"
+	buildahConfigEnvName   string = "DRAGONFLY_REGISTRIES_CONF"
+)
+
+// DefaultImageBuilderImages are the image names the builders are recognized by.
+var DefaultImageBuilderImages = map[string][]string{
+	ImageBuilderBuildKit: {"docker.io/moby/buildkit"},
+	ImageBuilderKaniko:   {"gcr.io/kaniko-project/executor", "gcr.io/kaniko-project/warmer"},
+	ImageBuilderBuildah:  {"quay.io/buildah/stable", "quay.io/containers/buildah"},
+}
+
+// ImageBuilderMirrors points image builders running in pods to the registry mirror of the node dfdaemon,
+// since the mirrors of the node container runtime don't apply to them.
+type ImageBuilderMirrors struct {
+	// Registries mirrored by the dfdaemon, docker.io when unset
+	Registries []string `yaml:"registries,omitempty" json:"registries,omitempty"`
+	// Port of the dfdaemon registry mirror, ProxyPort when unset
+	Port int `yaml:"port,omitempty" json:"port,omitempty"`
+	// Image name prefixes recognizing each builder, replacing those of DefaultImageBuilderImages
+	Images map[string][]string `yaml:"images,omitempty" json:"images,omitempty"`
+	// Config file paths in the builder containers, DefaultBuildKitConfigPath and DefaultBuildahConfigPath when unset
+	BuildKitConfigPath string `yaml:"buildKitConfigPath,omitempty" json:"buildKitConfigPath,omitempty"`
+	BuildahConfigPath  string `yaml:"buildahConfigPath,omitempty" json:"buildahConfigPath,omitempty"`
+	// Image of the initContainer writing the config files, which runs sh and printf,
+	// DefaultImageBuilderConfigImage when unset
+	ConfigImage string `yaml:"configImage,omitempty" json:"configImage,omitempty"`
+}
+
+func (m *ImageBuilderMirrors) validate() error {
+	for builder := range m.Images {
+		if !validImageBuilder(builder) {
+			return fmt.Errorf("invalid image builder %q", builder)
+		}
+	}
+	for _, p := range []string{m.BuildKitConfigPath, m.BuildahConfigPath} {
+		if p != "" && !path.IsAbs(p) {
+			return fmt.Errorf("image builder config path %q must be absolute", p)
+		}
+	}
+	for _, registry := range m.Registries {
+		if registry == "" || strings.Contains(registry, "/") {
+			return errors.New("image builder mirror registries must be registry hosts")
+		}
+	}
+	return nil
+}
+
+func validImageBuilder(builder string) bool {
+	return builder == ImageBuilderBuildKit || builder == ImageBuilderKaniko || builder == ImageBuilderBuildah
+}
+
+func (m *ImageBuilderMirrors) configImage() string {
+	if m.ConfigImage != "" {
+		return m.ConfigImage
+	}
+	return DefaultImageBuilderConfigImage
+}
+
+func (m *ImageBuilderMirrors) registries() []string {
+	if len(m.Registries) != 0 {
+		return m.Registries
+	}
+	return []string{defaultImageRegistry}
+}
+
+// mirrorHost returns the host and port of the dfdaemon registry mirror, expanded by the kubelet.
+func (m *ImageBuilderMirrors) mirrorHost(config *InjectConf, admission *PodAdmission) string {
+	port := m.Port
+	if port == 0 {
+		port = config.ProxyPort
+	}
+	return dfdaemonHost(config, admission, kubeletEnvRef) + ":" + strconv.Itoa(port)
+}
+
+// builderForImage returns the builder recognized by the image name, or an empty string.
+func (m *ImageBuilderMirrors) builderForImage(image string) string {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return ""
+	}
+	name := ref.Name()
+	for _, builder := range []string{ImageBuilderBuildKit, ImageBuilderKaniko, ImageBuilderBuildah} {
+		prefixes, ok := m.Images[builder]
+		if !ok {
+			prefixes = DefaultImageBuilderImages[builder]
+		}
+		for _, prefix := range prefixes {
+			prefix = normalizeImagePrefix(prefix)
+			if name == prefix || strings.HasPrefix(name, prefix+"/") {
+				return builder
+			}
+		}
+	}
+	return ""
+}
+
+// imageBuildersForPod returns the builder of each container, recognized by its image or set by the
+// ImageBuilderAnnotation, and a warning about invalid annotation entries. The annotation lists
+// builders of containers as "container=builder", or a single builder of all containers.
+func imageBuildersForPod(config *InjectConf, pod *corev1.Pod) ([]string, string) {
+	mirrors := config.ImageBuilderMirrors
+	if mirrors == nil {
+		return nil, ""
+	}
+	builders := make([]string, len(pod.Spec.Containers))
+	for i, c := range pod.Spec.Containers {
+		builders[i] = mirrors.builderForImage(c.Image)
+	}
+
+	value, ok := pod.GetAnnotations()[ImageBuilderAnnotation]
+	if !ok {
+		return builders, ""
+	}
+	var invalid []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, builder, named := strings.Cut(entry, "=")
+		if !named {
+			builder = name
+		}
+		i := slices.IndexFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == name })
+		if !validImageBuilder(builder) || named && i < 0 {
+			invalid = append(invalid, entry)
+			continue
+		}
+		if named {
+			builders[i] = builder
+			continue
+		}
+		for i := range builders {
+			builders[i] = builder
+		}
+	}
+	if len(invalid) != 0 {
+		return builders, fmt.Sprintf("ignore invalid entries of annotation %s: %s", ImageBuilderAnnotation, strings.Join(invalid, ","))
+	}
+	return builders, ""
+}
+
+// buildKitConfig returns the buildkitd.toml mirroring the registries through the dfdaemon.
+func (m *ImageBuilderMirrors) buildKitConfig(mirrorHost string) string {
+	var b strings.Builder
+	for _, registry := range m.registries() {
+		fmt.Fprintf(&b, "[registry.%q]\n  mirrors = [%q]\n\n", registry, mirrorHost)
+	}
+	fmt.Fprintf(&b, "[registry.%q]\n  http = true\n", mirrorHost)
+	return b.String()
+}
+
+// buildahConfig returns the registries.conf drop-in mirroring the registries through the dfdaemon.
+func (m *ImageBuilderMirrors) buildahConfig(mirrorHost string) string {
+	var b strings.Builder
+	for _, registry := range m.registries() {
+		fmt.Fprintf(&b, "[[registry]]\nprefix = %q\nlocation = %q\n\n[[registry.mirror]]\nlocation = %q\ninsecure = true\n\n",
+			registry, registry, mirrorHost)
+	}
+	return b.String()
+}
+
+// kanikoArgs returns the Kaniko flags pulling the registries through the dfdaemon.
+func (m *ImageBuilderMirrors) kanikoArgs(mirrorHost string) []string {
+	var args []string
+	for _, registry := range m.registries() {
+		if registry == defaultImageRegistry {
+			args = append(args, "--registry-mirror="+mirrorHost)
+		} else {
+			args = append(args, "--registry-map="+registry+"="+mirrorHost)
+		}
+	}
+	return append(args, "--insecure-registry="+mirrorHost)
+}
+
+type ImageBuilderInjector struct{}
+
+func NewImageBuilderInjector() *ImageBuilderInjector {
+	return &ImageBuilderInjector{}
+}
+
+func (ibi *ImageBuilderInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
+	builders, _ := imageBuildersForPod(config, pod)
+	if !slices.ContainsFunc(builders, func(b string) bool { return b != "" }) {
+		return
+	}
+	podlog.Info("ImageBuilderInjector Inject", "builders", builders)
+
+	mirrors := config.ImageBuilderMirrors
+	mirrorHost := mirrors.mirrorHost(config, admission)
+	needsConfig := false
+	for i, builder := range builders {
+		c := &pod.Spec.Containers[i]
+		switch builder {
+		case ImageBuilderKaniko:
+			injectContainer(config, admission, c, dfdaemonHostEnvs(config))
+			for _, arg := range mirrors.kanikoArgs(mirrorHost) {
+				if !slices.Contains(c.Args, arg) {
+					c.Args = append(c.Args, arg)
+				}
+			}
+		case ImageBuilderBuildKit:
+			needsConfig = true
+			configPath := mirrors.BuildKitConfigPath
+			if configPath == "" {
+				configPath = DefaultBuildKitConfigPath
+			}
+			addVolumeMount(config, admission, c, corev1.VolumeMount{
+				Name:      ImageBuilderConfigVolumeName,
+				MountPath: configPath,
+				SubPath:   buildKitConfigFileName,
+				ReadOnly:  true,
+			})
+		case ImageBuilderBuildah:
+			needsConfig = true
+			configPath := mirrors.BuildahConfigPath
+			if configPath == "" {
+				configPath = DefaultBuildahConfigPath
+			}
+			addVolumeMount(config, admission, c, corev1.VolumeMount{
+				Name:      ImageBuilderConfigVolumeName,
+				MountPath: configPath,
+				SubPath:   buildahConfigFileName,
+				ReadOnly:  true,
+			})
+		}
+	}
+	if !needsConfig {
+		return
+	}
+
+	addVolume(config, admission, pod, corev1.Volume{
+		Name:         ImageBuilderConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	addInitContainer(config, admission, pod, ibi.configContainer(config, mirrorHost))
+}
+
+// configContainer returns the initContainer writing the builder configs. The configs reference the node
+// name or IP, which only the kubelet can expand, so they are passed as env vars and written at startup
+// by the shell of the config image.
+func (ibi *ImageBuilderInjector) configContainer(config *InjectConf, mirrorHost string) corev1.Container {
+	const configDir = "/etc/dragonfly/builder"
+	mirrors := config.ImageBuilderMirrors
+	return corev1.Container{
+		Name:            ImageBuilderInitContainerName,
+		Image:           config.rewriteImage(mirrors.configImage()),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command: []string{"sh", "-c", fmt.Sprintf(`printf '%%s' "$%s" > %s && printf '%%s' "$%s" > %s`,
+			buildKitConfigEnvName, path.Join(configDir, buildKitConfigFileName),
+			buildahConfigEnvName, path.Join(configDir, buildahConfigFileName)),
+		},
+		Env: append(dfdaemonHostEnvs(config),
+			corev1.EnvVar{Name: buildKitConfigEnvName, Value: mirrors.buildKitConfig(mirrorHost)},
+			corev1.EnvVar{Name: buildahConfigEnvName, Value: mirrors.buildahConfig(mirrorHost)},
+		),
+		Resources:       *config.CliToolsResources.DeepCopy(),
+		SecurityContext: config.cliToolsSecurityContext().DeepCopy(),
+		VolumeMounts:    []corev1.VolumeMount{{Name: ImageBuilderConfigVolumeName, MountPath: configDir}},
+	}
+}
+
+// Admit warns about invalid entries of the image builder annotation.
+func (ibi *ImageBuilderInjector) Admit(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) ([]string, error) {
+	if _, warning := imageBuildersForPod(config, pod); warning != "" {
+		return []string{warning}, nil
+	}
+	return nil, nil
+}
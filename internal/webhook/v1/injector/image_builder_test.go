@@ -0,0 +1,140 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("ImageBuilderInjector", func() {
+	var (
+		config *InjectConf
+		pod    *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.ImageBuilderMirrors = &ImageBuilderMirrors{Port: 65001}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: map[string]string{}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "build", Image: "gcr.io/kaniko-project/executor:v1.23.2", Args: []string{"--destination=registry/app"}},
+				{Name: "sidecar", Image: "busybox"},
+			}},
+		}
+	})
+
+	It("should add the registry mirror args to kaniko containers", func() {
+		config.ImageBuilderMirrors.Registries = []string{"docker.io", "ghcr.io"}
+		NewImageBuilderInjector().Inject(pod, config, nil)
+		NewImageBuilderInjector().Inject(pod, config, nil)
+
+		build := pod.Spec.Containers[0]
+		Expect(build.Args).To(Equal([]string{
+			"--destination=registry/app",
+			"--registry-mirror=$(NODE_NAME):65001",
+			"--registry-map=ghcr.io=$(NODE_NAME):65001",
+			"--insecure-registry=$(NODE_NAME):65001",
+		}))
+		Expect(build.Env).To(ConsistOf(nodeNameEnv()))
+		Expect(pod.Spec.Containers[1].Args).To(BeEmpty())
+		Expect(pod.Spec.InitContainers).To(BeEmpty())
+		Expect(pod.Spec.Volumes).To(BeEmpty())
+	})
+
+	It("should mount the generated buildkitd.toml and registries.conf", func() {
+		pod.Spec.Containers[0].Image = "moby/buildkit:v0.16.0"
+		pod.Spec.Containers[1].Image = "quay.io/buildah/stable:latest"
+		NewImageBuilderInjector().Inject(pod, config, nil)
+
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+			Name: ImageBuilderConfigVolumeName, MountPath: DefaultBuildKitConfigPath, SubPath: "buildkitd.toml", ReadOnly: true,
+		}))
+		Expect(pod.Spec.Containers[1].VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+			Name: ImageBuilderConfigVolumeName, MountPath: DefaultBuildahConfigPath, SubPath: "registries.conf", ReadOnly: true,
+		}))
+		Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+			Name:         ImageBuilderConfigVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}))
+
+		By("writing the configs with the node name expanded by the kubelet")
+		Expect(pod.Spec.InitContainers).To(HaveLen(1))
+		init := pod.Spec.InitContainers[0]
+		Expect(init.Name).To(Equal(ImageBuilderInitContainerName))
+		Expect(init.Image).To(Equal(DefaultImageBuilderConfigImage))
+		Expect(init.Env).To(Equal([]corev1.EnvVar{
+			nodeNameEnv(),
+			{Name: "DRAGONFLY_BUILDKITD_TOML", Value: `[registry."docker.io"]
+  mirrors = ["$(NODE_NAME):65001"]
+
+[registry."$(NODE_NAME):65001"]
+  http = true
+`},
+			{Name: "DRAGONFLY_REGISTRIES_CONF", Value: `[[registry]]
+prefix = "docker.io"
+location = "docker.io"
+
+[[registry.mirror]]
+location = "$(NODE_NAME):65001"
+insecure = true
+
+`},
+		}))
+		Expect(init.Command).To(Equal([]string{"sh", "-c",
+			`printf '%s' "$DRAGONFLY_BUILDKITD_TOML" > /etc/dragonfly/builder/buildkitd.toml && ` +
+				`printf '%s' "$DRAGONFLY_REGISTRIES_CONF" > /etc/dragonfly/builder/registries.conf`}))
+	})
+
+	It("should write the configs with the configured image", func() {
+		pod.Spec.Containers[0].Image = "moby/buildkit:v0.16.0"
+		config.ImageBuilderMirrors.ConfigImage = "alpine:3.20"
+		config.ImageRewrites = map[string]string{"docker.io": "mirror.local"}
+		NewImageBuilderInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.InitContainers[0].Image).To(Equal("mirror.local/library/alpine:3.20"))
+	})
+
+	It("should recognize builders by annotation", func() {
+		pod.Spec.Containers[0].Image = "registry.internal/ci/builder:v1"
+		pod.Annotations[ImageBuilderAnnotation] = "build=buildah, sidecar=podman,missing=kaniko"
+
+		builders, warning := imageBuildersForPod(config, pod)
+		Expect(builders).To(Equal([]string{ImageBuilderBuildah, ""}))
+		Expect(warning).To(ContainSubstring("sidecar=podman,missing=kaniko"))
+
+		pod.Annotations[ImageBuilderAnnotation] = "kaniko"
+		builders, warning = imageBuildersForPod(config, pod)
+		Expect(builders).To(Equal([]string{ImageBuilderKaniko, ImageBuilderKaniko}))
+		Expect(warning).To(BeEmpty())
+	})
+
+	It("should recognize builders by the configured images", func() {
+		config.ImageBuilderMirrors.Images = map[string][]string{ImageBuilderKaniko: {"registry.internal/ci"}}
+		pod.Spec.Containers[1].Image = "registry.internal/ci/kaniko:v1"
+
+		builders, _ := imageBuildersForPod(config, pod)
+		Expect(builders).To(Equal([]string{"", ImageBuilderKaniko}))
+	})
+
+	It("should do nothing without builders", func() {
+		pod.Spec.Containers[0].Image = "nginx"
+		NewImageBuilderInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.Containers[0].Args).To(Equal([]string{"--destination=registry/app"}))
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+
+	It("should validate the image builder mirrors", func() {
+		Expect(config.validate()).To(Succeed())
+
+		config.ImageBuilderMirrors.Registries = []string{"docker.io/library"}
+		Expect(config.validate()).To(MatchError(ContainSubstring("must be registry hosts")))
+
+		config.ImageBuilderMirrors.Registries = nil
+		config.ImageBuilderMirrors.BuildKitConfigPath = "buildkitd.toml"
+		Expect(config.validate()).To(MatchError(ContainSubstring("must be absolute")))
+
+		config.ImageBuilderMirrors.Images = map[string][]string{"podman": {"quay.io/podman/stable"}}
+		Expect(config.validate()).To(MatchError(ContainSubstring(`invalid image builder "podman"`)))
+	})
+})
@@ -0,0 +1,420 @@
+package injector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DefaultImageDigestCacheTTL = 5 * time.Minute // How long a resolved digest is used before resolving it again
+	DefaultImageDigestTimeout  = 3 * time.Second // Timeout of a resolution, admission requests time out after 10s
+
+	dockerHubRegistryHost = "registry-1.docker.io"
+	dockerHubTokenHost    = "auth.docker.io"
+	maxManifestSize       = 4 << 20
+)
+
+// manifestMediaTypes are the manifest types accepted from registries, multi-arch indexes first,
+// so the digest of a manifest list stays valid on every architecture.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// CliToolsImageResolution pins the cli tools image to the digest its tag points to at admission time.
+type CliToolsImageResolution struct {
+	// How long a resolved digest is cached, DefaultImageDigestCacheTTL when unset
+	CacheTTL *metav1.Duration `yaml:"cacheTTL,omitempty" json:"cacheTTL,omitempty"`
+	// Timeout of a resolution, DefaultImageDigestTimeout when unset
+	Timeout *metav1.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// What to do when the digest can't be resolved, ImagePolicyActionIgnore injects the tag when unset
+	FailurePolicy string `yaml:"failurePolicy,omitempty" json:"failurePolicy,omitempty"`
+	// Registries, with their port, served over plain HTTP
+	InsecureRegistries []string `yaml:"insecureRegistries,omitempty" json:"insecureRegistries,omitempty"`
+	// Registries, with their port, whose images are resolved, the registries of the configured cli tools images when
+	// empty. Images of other registries, and images of the pod annotation without an image policy, are never resolved
+	Registries []string `yaml:"registries,omitempty" json:"registries,omitempty"`
+	// Hosts, with their port, of the token endpoints the registries may send in their Bearer challenges, besides
+	// the registry itself and auth.docker.io for Docker Hub
+	TokenRealms []string `yaml:"tokenRealms,omitempty" json:"tokenRealms,omitempty"`
+}
+
+func (r *CliToolsImageResolution) validate() error {
+	switch r.FailurePolicy {
+	case "", ImagePolicyActionIgnore, ImagePolicyActionReject:
+	default:
+		return fmt.Errorf("invalid cli tools image resolution failure policy %q", r.FailurePolicy)
+	}
+	if r.CacheTTL != nil && r.CacheTTL.Duration <= 0 {
+		return fmt.Errorf("cli tools image resolution cache TTL %s must be positive", r.CacheTTL.Duration)
+	}
+	if r.Timeout != nil && r.Timeout.Duration <= 0 {
+		return fmt.Errorf("cli tools image resolution timeout %s must be positive", r.Timeout.Duration)
+	}
+	return nil
+}
+
+func (r *CliToolsImageResolution) cacheTTL() time.Duration {
+	if r.CacheTTL != nil {
+		return r.CacheTTL.Duration
+	}
+	return DefaultImageDigestCacheTTL
+}
+
+func (r *CliToolsImageResolution) timeout() time.Duration {
+	if r.Timeout != nil && r.Timeout.Duration > 0 {
+		return r.Timeout.Duration
+	}
+	return DefaultImageDigestTimeout
+}
+
+// PullSecretsFunc returns the pull secrets of the cli tools image, whose credentials authenticate the
+// resolutions. It is only called when the digest isn't cached.
+type PullSecretsFunc func(ctx context.Context) []corev1.Secret
+
+// DigestResolver resolves image tags to digests through the OCI distribution API, and caches them.
+// Registries are authenticated with the cli tools pull secrets, or anonymously without them.
+type DigestResolver struct {
+	client *http.Client
+	now    func() time.Time
+	// group shares a resolution between the concurrent admissions of an image
+	group singleflight.Group
+
+	mu    sync.Mutex
+	cache map[string]resolvedDigest
+}
+
+type resolvedDigest struct {
+	digest  string
+	expires time.Time
+}
+
+func NewDigestResolver(client *http.Client) *DigestResolver {
+	restricted := *client
+	restricted.CheckRedirect = sameHostRedirect
+	return &DigestResolver{
+		client: &restricted,
+		now:    time.Now,
+		cache:  map[string]resolvedDigest{},
+	}
+}
+
+// PinCliToolsImage pins the cli tools image of the pod to its digest in the admission. When the digest
+// can't be resolved, the previously resolved digest is used even if it expired, otherwise the tag is kept
+// and the warning explains why, or an error is returned if the failure policy rejects the pod.
+// pullSecrets may be nil.
+func (r *DigestResolver) PinCliToolsImage(
+	ctx context.Context, config *InjectConf, admission *PodAdmission, pod *corev1.Pod, pullSecrets PullSecretsFunc,
+) (string, error) {
+	resolution := config.CliToolsImageResolution
+	// The node cache of the HostPath delivery mode is keyed by the tag the node agent installed.
+	if resolution == nil || config.CliToolsDeliveryMode == CliToolsDeliveryModeHostPath {
+		return "", nil
+	}
+
+	image := CliToolsImageForPod(config, admission, pod)
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return "", nil // reported by the image policy
+	}
+	if ref.Digest != "" {
+		return "", nil
+	}
+	// The webhook never contacts registries chosen by pods
+	if _, annotated := pod.GetAnnotations()[CliToolsImageAnnotation]; annotated && config.imagePolicy() == nil {
+		return fmt.Sprintf(
+			"cli tools image %q is not pinned to a digest: images of annotation %s are only resolved with an image policy",
+			image, CliToolsImageAnnotation), nil
+	}
+	if !slices.Contains(config.digestRegistries(), ref.Registry) {
+		return fmt.Sprintf(
+			"cli tools image %q is not pinned to a digest: registry %q is not resolved", image, ref.Registry), nil
+	}
+
+	digest, err := r.resolve(ctx, ref, resolution, pullSecrets)
+	if err != nil {
+		if resolution.FailurePolicy == ImagePolicyActionReject {
+			return "", fmt.Errorf("failed to resolve the digest of cli tools image %q: %w", image, err)
+		}
+		podlog.Error(err, "failed to resolve cli tools image digest", "image", image)
+		return fmt.Sprintf("cli tools image %q is not pinned to a digest: %v", image, err), nil
+	}
+
+	ref.Digest = digest
+	admission.cliToolsImage = ref.String()
+	return "", nil
+}
+
+// resolve returns the digest of the tagged image, from the cache while it is fresh. Concurrent
+// resolutions of the image share a single request to the registry.
+func (r *DigestResolver) resolve(
+	ctx context.Context, ref *imageReference, resolution *CliToolsImageResolution, pullSecrets PullSecretsFunc,
+) (string, error) {
+	key := ref.String()
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		return cached.digest, nil
+	}
+
+	digest, err, _ := r.group.Do(key, func() (any, error) {
+		// the shared resolution must not be canceled with the admission that started it
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resolution.timeout())
+		defer cancel()
+		var credentials *registryCredentials
+		if pullSecrets != nil {
+			credentials = pullSecretCredentials(pullSecrets(ctx), ref.Registry)
+		}
+		digest, err := r.fetchDigest(ctx, ref, resolution, credentials)
+		if err != nil {
+			return "", err
+		}
+		r.mu.Lock()
+		r.cache[key] = resolvedDigest{digest: digest, expires: r.now().Add(resolution.cacheTTL())}
+		r.mu.Unlock()
+		return digest, nil
+	})
+	if err != nil {
+		if ok {
+			podlog.Error(err, "use expired cli tools image digest", "image", key, "digest", cached.digest)
+			return cached.digest, nil
+		}
+		return "", err
+	}
+	return digest.(string), nil
+}
+
+// fetchDigest asks the registry for the digest of the manifest the tag points to. credentials may be nil.
+func (r *DigestResolver) fetchDigest(
+	ctx context.Context, ref *imageReference, resolution *CliToolsImageResolution, credentials *registryCredentials,
+) (string, error) {
+	scheme, host := "https", ref.Registry
+	insecure := slices.Contains(resolution.InsecureRegistries, ref.Registry)
+	if insecure {
+		scheme = "http"
+	}
+	realms := append([]string{host}, resolution.TokenRealms...)
+	if host == defaultImageRegistry {
+		host = dockerHubRegistryHost
+		realms = append(realms, dockerHubRegistryHost, dockerHubTokenHost)
+	}
+	tag := ref.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, ref.Repository, tag)
+
+	var authorization string
+	resp, err := r.getManifest(ctx, http.MethodHead, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if authorization, err = r.authorize(ctx, challenge, realms, insecure, credentials); err != nil {
+			return "", err
+		}
+		if resp, err = r.getManifest(ctx, http.MethodHead, manifestURL, authorization); err != nil {
+			return "", err
+		}
+		_ = resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, manifestURL)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return r.hashManifest(ctx, manifestURL, authorization)
+	}
+	if !imageDigestRegexp.MatchString(digest) {
+		return "", fmt.Errorf("registry returned invalid digest %q for %s", digest, manifestURL)
+	}
+	return digest, nil
+}
+
+// hashManifest computes the digest of the manifest, for registries that don't return it in a header.
+func (r *DigestResolver) hashManifest(ctx context.Context, manifestURL string, authorization string) (string, error) {
+	resp, err := r.getManifest(ctx, http.MethodGet, manifestURL, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, manifestURL)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(resp.Body, maxManifestSize)); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (r *DigestResolver) getManifest(
+	ctx context.Context, method string, manifestURL string, authorization string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.client.Do(req)
+}
+
+// authorize returns the Authorization header answering the challenge of the registry.
+func (r *DigestResolver) authorize(
+	ctx context.Context, challenge string, realms []string, insecure bool, credentials *registryCredentials,
+) (string, error) {
+	scheme, _, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "Basic") && credentials != nil {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials.username+":"+credentials.password)), nil
+	}
+	token, err := r.fetchToken(ctx, challenge, realms, insecure, credentials)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// fetchToken gets a pull token from the realm of a Bearer challenge, anonymously if credentials is nil.
+// The realm must be one of the allowed hosts, over HTTPS unless the registry is insecure.
+func (r *DigestResolver) fetchToken(
+	ctx context.Context, challenge string, realms []string, insecure bool, credentials *registryCredentials,
+) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	values := parseAuthParams(params)
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry token realm %q", values["realm"])
+	}
+	if !slices.Contains(realms, realm.Host) || realm.Scheme != "https" && !(insecure && realm.Scheme == "http") {
+		return "", fmt.Errorf("registry token realm %q is not allowed", values["realm"])
+	}
+	query := realm.Query()
+	for _, name := range []string{"service", "scope"} {
+		if value, ok := values[name]; ok {
+			query.Set(name, value)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if credentials != nil {
+		req.SetBasicAuth(credentials.username, credentials.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token endpoint returned %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid registry token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", errors.New("registry token response without a token")
+}
+
+// sameHostRedirect stops redirects to other hosts, registries are only contacted at the allowed hosts.
+func sameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("redirect to %q is not allowed", req.URL.Host)
+	}
+	return nil
+}
+
+// digestRegistries returns the registries whose images are resolved: the configured ones, or the registries
+// of the configured cli tools images.
+func (c *InjectConf) digestRegistries() []string {
+	if c.CliToolsImageResolution != nil && len(c.CliToolsImageResolution.Registries) > 0 {
+		registries := make([]string, 0, len(c.CliToolsImageResolution.Registries))
+		for _, registry := range c.CliToolsImageResolution.Registries {
+			if registry == legacyImageRegistry {
+				registry = defaultImageRegistry
+			}
+			registries = append(registries, registry)
+		}
+		return registries
+	}
+	if c.compiled != nil {
+		return c.compiled.cliToolsImageRegistries
+	}
+	return cliToolsImageRegistries(c)
+}
+
+// cliToolsImageRegistries returns the registries of the configured cli tools images, after the image rewrites.
+func cliToolsImageRegistries(c *InjectConf) []string {
+	var registries []string
+	images := []string{c.CliToolsImage}
+	for _, image := range c.CliToolsArchImages {
+		images = append(images, image)
+	}
+	for _, image := range images {
+		ref, err := parseImageReference(c.rewriteImage(image))
+		if err == nil && !slices.Contains(registries, ref.Registry) {
+			registries = append(registries, ref.Registry)
+		}
+	}
+	return registries
+}
+
+// parseAuthParams parses the comma separated key="value" parameters of a WWW-Authenticate challenge.
+func parseAuthParams(params string) map[string]string {
+	values := map[string]string{}
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			values[strings.ToLower(key)] = value
+		}
+	}
+	return values
+}
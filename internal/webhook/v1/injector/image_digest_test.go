@@ -0,0 +1,341 @@
+package injector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testRegistry is an in-process OCI distribution registry serving the manifests of one repository.
+type testRegistry struct {
+	server    *httptest.Server
+	manifests map[string]string // manifest body by tag
+	token     string            // bearer token required by the registry, none when empty
+	username  string            // username and password required by the token endpoint, none when empty
+	password  string
+	delay     time.Duration // delay of the manifest responses
+	noDigest  bool          // whether to omit the Docker-Content-Digest header
+	requests  atomic.Int32  // manifest requests
+}
+
+func newTestRegistry(repository string) *testRegistry {
+	r := &testRegistry{manifests: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("scope") != "repository:"+repository+":pull" {
+			http.Error(w, "invalid scope", http.StatusBadRequest)
+			return
+		}
+		if username, password, _ := req.BasicAuth(); username != r.username || password != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"token": %q}`, r.token)
+	})
+	mux.HandleFunc("/v2/"+repository+"/manifests/", func(w http.ResponseWriter, req *http.Request) {
+		r.requests.Add(1)
+		time.Sleep(r.delay)
+		if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="test-registry",scope="repository:%s:pull"`, r.server.URL, repository))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		manifest, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/"+repository+"/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		if !r.noDigest {
+			w.Header().Set("Docker-Content-Digest", manifestDigest(manifest))
+		}
+		if req.Method == http.MethodGet {
+			_, _ = w.Write([]byte(manifest))
+		}
+	})
+	r.server = httptest.NewServer(mux)
+	return r
+}
+
+// host returns the registry host and port, as used in image references.
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func manifestDigest(manifest string) string {
+	sum := sha256.Sum256([]byte(manifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+var _ = Describe("DigestResolver", func() {
+	var (
+		registry *testRegistry
+		resolver *DigestResolver
+		config   *InjectConf
+		pod      *corev1.Pod
+		now      time.Time
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		registry = newTestRegistry("dragonflyoss/cli-tools")
+		DeferCleanup(registry.server.Close)
+		registry.manifests["v1"] = `{"schemaVersion":2,"manifests":[]}`
+
+		now = time.Now()
+		resolver = NewDigestResolver(registry.server.Client())
+		resolver.now = func() time.Time { return now }
+
+		config = &InjectConf{
+			CliToolsDirPath: CliToolsDirPath,
+			CliToolsImage:   registry.host() + "/dragonflyoss/cli-tools:v1",
+			CliToolsImageResolution: &CliToolsImageResolution{
+				InsecureRegistries: []string{registry.host()},
+			},
+		}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}}
+	})
+
+	// pin pins the cli tools image of the pod in a new admission, and returns it.
+	pin := func(config *InjectConf, pod *corev1.Pod, pullSecrets PullSecretsFunc) (*PodAdmission, string, error) {
+		admission := &PodAdmission{}
+		warning, err := resolver.PinCliToolsImage(ctx, config, admission, pod, pullSecrets)
+		return admission, warning, err
+	}
+
+	It("should pin the image to the digest of its tag", func() {
+		pinned, warning, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(BeEmpty())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(Equal(config.CliToolsImage + "@" + manifestDigest(registry.manifests["v1"])))
+		Expect(CliToolsImageForPod(config, nil, pod)).To(Equal(config.CliToolsImage))
+
+		By("injecting the pinned image")
+		NewToolsInitcontainerInjector().Inject(pod, config, pinned)
+		Expect(pod.Spec.InitContainers[0].Image).To(HaveSuffix("@" + manifestDigest(registry.manifests["v1"])))
+	})
+
+	It("should cache the digest until it expires", func() {
+		config.CliToolsImageResolution.CacheTTL = &metav1.Duration{Duration: time.Minute}
+		oldDigest := manifestDigest(registry.manifests["v1"])
+		_, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("moving the tag")
+		registry.manifests["v1"] = `{"schemaVersion":2,"manifests":[{}]}`
+		pinned, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(oldDigest))
+		Expect(registry.requests.Load()).To(BeEquivalentTo(1))
+
+		By("resolving again after the TTL")
+		now = now.Add(time.Minute)
+		pinned, _, err = pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+	})
+
+	It("should get a token from registries requiring one", func() {
+		registry.token = "anonymous-pull-token"
+		pinned, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+	})
+
+	It("should get a token with the credentials of the pull secrets", func() {
+		registry.token = "private-pull-token"
+		registry.username, registry.password = "user", "secret"
+		_, warning, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(ContainSubstring("401"))
+
+		By("resolving with the pull secrets")
+		auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		pullSecrets := func(context.Context) []corev1.Secret {
+			return []corev1.Secret{
+				{Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"other.registry":{"auth":"b3RoZXI6b3RoZXI="}}}`)}},
+				{Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(
+					fmt.Sprintf(`{"auths":{"http://%s/v2/":{"auth":%q}}}`, registry.host(), auth))}},
+			}
+		}
+		pinned, warning, err := pin(config, pod, pullSecrets)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(BeEmpty())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+	})
+
+	It("should read the registry credentials of pull secrets", func() {
+		secret := corev1.Secret{Data: map[string][]byte{
+			corev1.DockerConfigKey: []byte(`{"https://index.docker.io/v1/":{"username":"user","password":"secret"}}`),
+		}}
+		Expect(pullSecretCredentials([]corev1.Secret{secret}, "docker.io")).To(Equal(&registryCredentials{username: "user", password: "secret"}))
+		Expect(pullSecretCredentials([]corev1.Secret{secret}, "ghcr.io")).To(BeNil())
+		Expect(dockerConfigRegistry("registry.local:5000")).To(Equal("registry.local:5000"))
+	})
+
+	It("should share the resolution of concurrent admissions", func() {
+		registry.delay = 100 * time.Millisecond
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				pinned, _, err := pin(config, pod, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+			}()
+		}
+		wg.Wait()
+		Expect(registry.requests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should validate the durations", func() {
+		config.CliToolsImageResolution.CacheTTL = &metav1.Duration{}
+		Expect(config.validate()).To(MatchError(ContainSubstring("cache TTL 0s must be positive")))
+		config.CliToolsImageResolution.CacheTTL = nil
+		config.CliToolsImageResolution.Timeout = &metav1.Duration{Duration: -time.Second}
+		Expect(config.validate()).To(MatchError(ContainSubstring("timeout -1s must be positive")))
+	})
+
+	It("should hash the manifest when the registry doesn't return its digest", func() {
+		registry.noDigest = true
+		pinned, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+	})
+
+	It("should resolve the annotated and rewritten image", func() {
+		registry.manifests["v2"] = `{"schemaVersion":2}`
+		config.ImageRewrites = map[string]string{"docker.io/dragonflyoss": registry.host() + "/dragonflyoss"}
+		pod.Annotations = map[string]string{CliToolsImageAnnotation: "dragonflyoss/cli-tools:v2"}
+		config.CliToolsImagePolicy = &CliToolsImagePolicy{AllowedRepositories: []string{"dragonflyoss/*"}}
+		pinned, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(CliToolsImageForPod(config, pinned, pod)).To(Equal(
+			registry.host() + "/dragonflyoss/cli-tools:v2@" + manifestDigest(registry.manifests["v2"])))
+	})
+
+	It("should not resolve images of the pod annotation without an image policy", func() {
+		pod.Annotations = map[string]string{CliToolsImageAnnotation: registry.host() + "/dragonflyoss/cli-tools:v1"}
+		pinned, warning, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(ContainSubstring("only resolved with an image policy"))
+		Expect(pinned.cliToolsImage).To(BeEmpty())
+		Expect(registry.requests.Load()).To(BeZero())
+	})
+
+	It("should only resolve images of the allowed registries", func() {
+		config.CliToolsImagePolicy = &CliToolsImagePolicy{}
+		config.compile()
+		pod.Annotations = map[string]string{CliToolsImageAnnotation: "169.254.169.254/latest/meta-data:v1"}
+		effective := EffectiveConfig(config, pod, nil)
+		_, warning, err := pin(effective, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(ContainSubstring(`registry "169.254.169.254" is not resolved`))
+
+		By("resolving the registries of the config")
+		config.CliToolsImageResolution.Registries = []string{"169.254.169.254"}
+		_, warning, err = pin(config, &corev1.Pod{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(ContainSubstring(fmt.Sprintf("registry %q is not resolved", registry.host())))
+		Expect(registry.requests.Load()).To(BeZero())
+	})
+
+	It("should only get tokens from the allowed realms", func() {
+		other := newTestRegistry("dragonflyoss/cli-tools")
+		DeferCleanup(other.server.Close)
+		other.token = "anonymous-pull-token"
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",scope="repository:dragonflyoss/cli-tools:pull"`, other.server.URL)
+
+		_, err := resolver.fetchToken(ctx, challenge, []string{registry.host()}, true, nil)
+		Expect(err).To(MatchError(ContainSubstring("is not allowed")))
+		_, err = resolver.fetchToken(ctx, challenge, []string{other.host()}, false, nil)
+		Expect(err).To(MatchError(ContainSubstring("is not allowed")))
+		Expect(resolver.fetchToken(ctx, challenge, []string{other.host()}, true, nil)).To(Equal(other.token))
+	})
+
+	It("should not follow redirects to other hosts", func() {
+		other := newTestRegistry("dragonflyoss/cli-tools")
+		DeferCleanup(other.server.Close)
+		redirect := httptest.NewServer(http.RedirectHandler(other.server.URL+"/v2/dragonflyoss/cli-tools/manifests/v1", http.StatusFound))
+		DeferCleanup(redirect.Close)
+		host := strings.TrimPrefix(redirect.URL, "http://")
+		config.CliToolsImage = host + "/dragonflyoss/cli-tools:v1"
+		config.CliToolsImageResolution.InsecureRegistries = []string{host}
+
+		_, warning, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warning).To(ContainSubstring("is not allowed"))
+		Expect(other.requests.Load()).To(BeZero())
+	})
+
+	It("should not resolve images that are already pinned or not configured to be", func() {
+		config.CliToolsImage += "@" + manifestDigest("pinned")
+		pinned, _, err := pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pinned.cliToolsImage).To(BeEmpty())
+
+		config.CliToolsImageResolution = nil
+		pinned, _, err = pin(config, pod, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pinned.cliToolsImage).To(BeEmpty())
+		Expect(registry.requests.Load()).To(BeZero())
+	})
+
+	Context("when the digest can't be resolved", func() {
+		BeforeEach(func() {
+			config.CliToolsImage = registry.host() + "/dragonflyoss/cli-tools:missing"
+		})
+
+		It("should inject the tag with a warning", func() {
+			pinned, warning, err := pin(config, pod, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warning).To(ContainSubstring("is not pinned to a digest"))
+			Expect(CliToolsImageForPod(config, pinned, pod)).To(Equal(config.CliToolsImage))
+		})
+
+		It("should reject the pod when configured", func() {
+			config.CliToolsImageResolution.FailurePolicy = ImagePolicyActionReject
+			_, _, err := pin(config, pod, nil)
+			Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+		})
+
+		It("should use an expired digest", func() {
+			config.CliToolsImage = registry.host() + "/dragonflyoss/cli-tools:v1"
+			config.CliToolsImageResolution.FailurePolicy = ImagePolicyActionReject
+			_, _, err := pin(config, pod, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			registry.server.Close()
+			now = now.Add(DefaultImageDigestCacheTTL)
+			pinned, _, err := pin(config, pod, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(CliToolsImageForPod(config, pinned, pod)).To(HaveSuffix(manifestDigest(registry.manifests["v1"])))
+		})
+	})
+
+	It("should parse WWW-Authenticate parameters", func() {
+		Expect(parseAuthParams(`realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:a/b:pull,push"`)).
+			To(Equal(map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:a/b:pull,push",
+			}))
+	})
+})
@@ -0,0 +1,148 @@
+package injector
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Actions for pods whose cli tools image can't be selected as requested, see CliToolsImagePolicy and CliToolsArchAction
+	ImagePolicyActionIgnore string = "Ignore" // Use the configured image and warn
+	ImagePolicyActionReject string = "Reject" // Reject the pod
+)
+
+// CliToolsImagePolicy restricts the images pods can request with the CliToolsImageAnnotation.
+// Image references and patterns are normalized, e.g. "dragonflyoss/*" means "docker.io/dragonflyoss/*".
+type CliToolsImagePolicy struct {
+	// Registries the requested image may come from, e.g. "docker.io" or "harbor.internal:5000"
+	AllowedRegistries []string `yaml:"allowedRegistries,omitempty" json:"allowedRegistries,omitempty"`
+	// Repository patterns the requested image may match, e.g. "docker.io/dragonflyoss/*", see path.Match
+	AllowedRepositories []string `yaml:"allowedRepositories,omitempty" json:"allowedRepositories,omitempty"`
+	// Whether the requested image must be pinned by digest
+	RequireDigest bool `yaml:"requireDigest,omitempty" json:"requireDigest,omitempty"`
+	// What to do with disallowed images, ImagePolicyActionIgnore when unset
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
+// compiledImagePolicy is a CliToolsImagePolicy with normalized registries and patterns.
+type compiledImagePolicy struct {
+	registries    []string
+	repositories  []string
+	requireDigest bool
+	reject        bool
+}
+
+func (p *CliToolsImagePolicy) validate() error {
+	switch p.Action {
+	case "", ImagePolicyActionIgnore, ImagePolicyActionReject:
+	default:
+		return fmt.Errorf("invalid cli tools image policy action %q", p.Action)
+	}
+	for _, pattern := range p.AllowedRepositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid cli tools image repository pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (p *CliToolsImagePolicy) compile() *compiledImagePolicy {
+	if p == nil {
+		return nil
+	}
+	compiled := &compiledImagePolicy{
+		requireDigest: p.RequireDigest,
+		reject:        p.Action == ImagePolicyActionReject,
+	}
+	for _, registry := range p.AllowedRegistries {
+		if registry == legacyImageRegistry {
+			registry = defaultImageRegistry
+		}
+		compiled.registries = append(compiled.registries, registry)
+	}
+	for _, pattern := range p.AllowedRepositories {
+		registry, repository := splitImageRegistry(pattern)
+		compiled.repositories = append(compiled.repositories, registry+"/"+repository)
+	}
+	return compiled
+}
+
+// allows returns why the image is not allowed, or an empty string if it is.
+func (p *compiledImagePolicy) allows(image string) string {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return err.Error()
+	}
+
+	if len(p.registries) > 0 || len(p.repositories) > 0 {
+		allowed := slices.Contains(p.registries, ref.Registry) ||
+			slices.ContainsFunc(p.repositories, func(pattern string) bool {
+				matched, _ := path.Match(pattern, ref.Name())
+				return matched
+			})
+		if !allowed {
+			return fmt.Sprintf("image %q is not from an allowed registry or repository", ref.Name())
+		}
+	}
+	if p.requireDigest && ref.Digest == "" {
+		return fmt.Sprintf("image %q is not pinned by digest", image)
+	}
+	return ""
+}
+
+// imagePolicy returns the compiled cli tools image policy, computing it if the config is not compiled.
+func (c *InjectConf) imagePolicy() *compiledImagePolicy {
+	if c.compiled != nil {
+		return c.compiled.imagePolicy
+	}
+	return c.CliToolsImagePolicy.compile()
+}
+
+// cliToolsImageForPod returns the cli tools image of the pod. The image requested by the
+// CliToolsImageAnnotation is only used if the image policy allows it, otherwise the image of the
+// pod's architecture, or the configured image, is used and the warnings explain why. An error is
+// returned if a policy rejects the pod.
+func cliToolsImageForPod(config *InjectConf, pod *corev1.Pod) (string, []string, error) {
+	image, ok := pod.GetAnnotations()[CliToolsImageAnnotation]
+	if !ok {
+		return cliToolsDefaultImageForPod(config, pod)
+	}
+
+	policy := config.imagePolicy()
+	if policy == nil {
+		return image, nil, nil
+	}
+	reason := policy.allows(image)
+	if reason == "" {
+		return image, nil, nil
+	}
+	if policy.reject {
+		return config.CliToolsImage, nil, fmt.Errorf("annotation %s is not allowed: %s", CliToolsImageAnnotation, reason)
+	}
+	image, warnings, err := cliToolsDefaultImageForPod(config, pod)
+	warning := fmt.Sprintf("annotation %s is ignored: %s, using %q", CliToolsImageAnnotation, reason, image)
+	return image, append([]string{warning}, warnings...), err
+}
+
+// CliToolsImageForPod returns the cli tools image injected into the pod, after the image rewrites
+// and the pinning of the admission, which may be nil. Whether the image is allowed is reported by
+// ToolsInitcontainerInjector.Admit.
+func CliToolsImageForPod(config *InjectConf, admission *PodAdmission, pod *corev1.Pod) string {
+	if admission != nil && admission.cliToolsImage != "" {
+		return admission.cliToolsImage
+	}
+	image, _, _ := cliToolsImageForPod(config, pod)
+	return config.rewriteImage(image)
+}
+
+// cliToolsDefaultImageForPod returns the image of the pod's architecture, or the configured image.
+func cliToolsDefaultImageForPod(config *InjectConf, pod *corev1.Pod) (string, []string, error) {
+	image, warning, err := cliToolsArchImage(config, pod)
+	if warning == "" {
+		return image, nil, err
+	}
+	return image, []string{warning}, err
+}
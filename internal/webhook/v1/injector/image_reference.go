@@ -0,0 +1,164 @@
+package injector
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultImageRegistry = "docker.io"
+	legacyImageRegistry  = "index.docker.io"
+	officialImagePrefix  = "library/"
+)
+
+var (
+	imageRepositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	imageTagRegexp        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	imageDigestRegexp     = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+// imageReference is a container image reference in its normalized form, e.g. "busybox" is
+// registry "docker.io" and repository "library/busybox".
+type imageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference parses and normalizes an image reference the way container runtimes do.
+func parseImageReference(image string) (*imageReference, error) {
+	ref := &imageReference{}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if !imageDigestRegexp.MatchString(ref.Digest) {
+			return nil, fmt.Errorf("invalid digest in image reference %q", image)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !imageTagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid tag in image reference %q", image)
+		}
+	}
+
+	ref.Registry, ref.Repository = splitImageRegistry(name)
+	if !imageRepositoryRegexp.MatchString(ref.Repository) {
+		return nil, fmt.Errorf("invalid repository in image reference %q", image)
+	}
+	return ref, nil
+}
+
+// splitImageRegistry splits an image name into its normalized registry and repository.
+func splitImageRegistry(name string) (string, string) {
+	registry, repository, found := strings.Cut(name, "/")
+	if !found || !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+		registry, repository = defaultImageRegistry, name
+	}
+	if registry == legacyImageRegistry {
+		registry = defaultImageRegistry
+	}
+	if registry == defaultImageRegistry && !strings.Contains(repository, "/") {
+		repository = officialImagePrefix + repository
+	}
+	return registry, repository
+}
+
+// Name returns the normalized image name, the registry and the repository.
+func (r *imageReference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the normalized image reference.
+func (r *imageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// imageRewriteRule replaces the normalized image name prefix with the replacement.
+type imageRewriteRule struct {
+	prefix      string
+	replacement string
+}
+
+// compileImageRewrites normalizes the prefixes of the rewrite rules, and orders the rules
+// by descending prefix length, so the most specific rule matches first.
+func compileImageRewrites(rewrites map[string]string) []imageRewriteRule {
+	rules := make([]imageRewriteRule, 0, len(rewrites))
+	for prefix, replacement := range rewrites {
+		rules = append(rules, imageRewriteRule{
+			prefix:      normalizeImagePrefix(prefix),
+			replacement: strings.TrimSuffix(replacement, "/"),
+		})
+	}
+	slices.SortFunc(rules, func(a, b imageRewriteRule) int {
+		if c := cmp.Compare(len(b.prefix), len(a.prefix)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.prefix, b.prefix)
+	})
+	return rules
+}
+
+// validateImageRewrites checks that the rewrite rules produce valid image references.
+func validateImageRewrites(rewrites map[string]string) error {
+	for prefix, replacement := range rewrites {
+		if strings.TrimSuffix(prefix, "/") == "" {
+			return fmt.Errorf("invalid image rewrite prefix %q", prefix)
+		}
+		ref, err := parseImageReference(strings.TrimSuffix(replacement, "/") + "/image")
+		if err != nil || ref.Tag != "" || ref.Digest != "" {
+			return fmt.Errorf("invalid image rewrite replacement %q for prefix %q", replacement, prefix)
+		}
+	}
+	return nil
+}
+
+// normalizeImagePrefix normalizes an image name prefix like splitImageRegistry, e.g. "dragonflyoss"
+// is "docker.io/dragonflyoss", but a prefix is never completed to an official image name.
+func normalizeImagePrefix(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	registry, repository, found := strings.Cut(prefix, "/")
+	if !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+		return defaultImageRegistry + "/" + prefix
+	}
+	if registry == legacyImageRegistry {
+		registry = defaultImageRegistry
+	}
+	if !found {
+		return registry
+	}
+	return registry + "/" + repository
+}
+
+// rewriteImage applies the first matching rewrite rule to the image. The prefix of a rule matches
+// whole path components of the normalized image name. Images without a matching rule, and invalid
+// references, are returned unchanged.
+func rewriteImage(rules []imageRewriteRule, image string) string {
+	if len(rules) == 0 {
+		return image
+	}
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return image
+	}
+	name := ref.Name()
+	for _, rule := range rules {
+		if name != rule.prefix && !strings.HasPrefix(name, rule.prefix+"/") {
+			continue
+		}
+		ref.Registry, ref.Repository = splitImageRegistry(rule.replacement + name[len(rule.prefix):])
+		return ref.String()
+	}
+	return image
+}
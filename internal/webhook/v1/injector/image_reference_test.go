@@ -0,0 +1,95 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseImageReference", func() {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	DescribeTable("should normalize image references",
+		func(image, expected string) {
+			ref, err := parseImageReference(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.String()).To(Equal(expected))
+		},
+		Entry("official image", "busybox", "docker.io/library/busybox"),
+		Entry("docker hub image with tag", "dragonflyoss/cli-tools:latest", "docker.io/dragonflyoss/cli-tools:latest"),
+		Entry("legacy docker hub registry", "index.docker.io/dragonflyoss/cli-tools", "docker.io/dragonflyoss/cli-tools"),
+		Entry("registry with port", "harbor.internal:5000/dragonfly/cli-tools:v1", "harbor.internal:5000/dragonfly/cli-tools:v1"),
+		Entry("localhost registry", "localhost/cli-tools", "localhost/cli-tools"),
+		Entry("digest", "ghcr.io/dragonflyoss/cli-tools@"+digest, "ghcr.io/dragonflyoss/cli-tools@"+digest),
+		Entry("tag and digest", "cli-tools:v1@"+digest, "docker.io/library/cli-tools:v1@"+digest),
+	)
+
+	DescribeTable("should reject invalid image references",
+		func(image string) {
+			_, err := parseImageReference(image)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("uppercase repository", "Dragonflyoss/cli-tools"),
+		Entry("invalid digest", "busybox@sha256:abc"),
+		Entry("invalid tag", "busybox:-latest"),
+	)
+})
+
+var _ = Describe("rewriteImage", func() {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	var rules []imageRewriteRule
+
+	BeforeEach(func() {
+		rules = compileImageRewrites(map[string]string{
+			"docker.io/dragonflyoss":     "harbor.internal/dragonfly",
+			"dragonflyoss/cli-tools":     "harbor.internal/tools/cli-tools",
+			"ghcr.io":                    "harbor.internal/ghcr/",
+			"index.docker.io/library":    "harbor.internal:5000/library",
+			"quay.io/dragonflyoss/other": "dragonfly-mirror",
+		})
+	})
+
+	DescribeTable("should rewrite matching images",
+		func(image, expected string) {
+			Expect(rewriteImage(rules, image)).To(Equal(expected))
+		},
+		Entry("familiar form", "dragonflyoss/dfget:v1", "harbor.internal/dragonfly/dfget:v1"),
+		Entry("normalized form", "docker.io/dragonflyoss/dfget:v1", "harbor.internal/dragonfly/dfget:v1"),
+		Entry("legacy form", "index.docker.io/dragonflyoss/dfget", "harbor.internal/dragonfly/dfget"),
+		Entry("longest prefix wins", "dragonflyoss/cli-tools:latest", "harbor.internal/tools/cli-tools:latest"),
+		Entry("registry prefix", "ghcr.io/dragonflyoss/cli-tools@"+digest, "harbor.internal/ghcr/dragonflyoss/cli-tools@"+digest),
+		Entry("official image", "busybox:1.36", "harbor.internal:5000/library/busybox:1.36"),
+		Entry("docker hub replacement", "quay.io/dragonflyoss/other:v1", "docker.io/library/dragonfly-mirror:v1"),
+	)
+
+	DescribeTable("should keep other images unchanged",
+		func(image string) {
+			Expect(rewriteImage(rules, image)).To(Equal(image))
+		},
+		Entry("other repository", "dragonfly/cli-tools:v1"),
+		Entry("partial path component", "dragonflyoss-fork/cli-tools:v1"),
+		Entry("other registry", "quay.io/dragonflyoss/cli-tools:v1"),
+		Entry("invalid reference", "Not An Image"),
+	)
+
+	It("should keep images unchanged without rules", func() {
+		Expect(rewriteImage(nil, "dragonflyoss/cli-tools:latest")).To(Equal("dragonflyoss/cli-tools:latest"))
+	})
+
+	DescribeTable("should validate rewrite rules",
+		func(prefix, replacement string, valid bool) {
+			err := validateImageRewrites(map[string]string{prefix: replacement})
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("registry replacement", "docker.io", "harbor.internal/dockerhub", true),
+		Entry("registry with port", "dragonflyoss", "harbor.internal:5000", true),
+		Entry("empty prefix", "", "harbor.internal", false),
+		Entry("replacement with tag", "dragonflyoss", "harbor.internal/dragonfly:v1", false),
+		Entry("invalid replacement", "dragonflyoss", "Harbor Internal", false),
+	)
+})
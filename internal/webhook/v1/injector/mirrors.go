@@ -0,0 +1,181 @@
+package injector
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Package ecosystems with a mirror, as listed in the MirrorsAnnotation
+const (
+	MirrorEcosystemPip         string = "pip"
+	MirrorEcosystemNpm         string = "npm"
+	MirrorEcosystemGo          string = "go"
+	MirrorEcosystemMaven       string = "maven"
+	MirrorEcosystemHuggingFace string = "huggingface"
+)
+
+// EcosystemMirrors are the package registry mirrors served by the node dfdaemon, for tools ignoring the
+// proxy env vars. Pods enable ecosystems with the MirrorsAnnotation, ecosystems without a path have no mirror.
+type EcosystemMirrors struct {
+	// Port of the dfdaemon serving the mirrors, ProxyPort when unset
+	Port int `yaml:"port,omitempty" json:"port,omitempty"`
+	// Paths of the mirrors on the dfdaemon, e.g. "/pypi/simple" for the PyPI simple index
+	Pip         string `yaml:"pip,omitempty" json:"pip,omitempty"`
+	Npm         string `yaml:"npm,omitempty" json:"npm,omitempty"`
+	Go          string `yaml:"go,omitempty" json:"go,omitempty"`
+	Maven       string `yaml:"maven,omitempty" json:"maven,omitempty"`
+	HuggingFace string `yaml:"huggingFace,omitempty" json:"huggingFace,omitempty"`
+}
+
+func (m *EcosystemMirrors) validate() error {
+	paths := m.paths()
+	for _, ecosystem := range slices.Sorted(maps.Keys(paths)) {
+		if p := paths[ecosystem]; p != "" && !path.IsAbs(p) {
+			return fmt.Errorf("%s mirror path %q must be absolute", ecosystem, p)
+		}
+	}
+	return nil
+}
+
+// paths returns the mirror paths by ecosystem.
+func (m *EcosystemMirrors) paths() map[string]string {
+	return map[string]string{
+		MirrorEcosystemPip:         m.Pip,
+		MirrorEcosystemNpm:         m.Npm,
+		MirrorEcosystemGo:          m.Go,
+		MirrorEcosystemMaven:       m.Maven,
+		MirrorEcosystemHuggingFace: m.HuggingFace,
+	}
+}
+
+// url returns the URL of the mirror on the node dfdaemon, with the host expanded from the env var
+// reference by the kubelet, or by Maven for its settings.
+func (m *EcosystemMirrors) url(config *InjectConf, hostRef string, mirrorPath string) string {
+	port := m.Port
+	if port == 0 {
+		port = config.ProxyPort
+	}
+	return "http://" + hostRef + ":" + strconv.Itoa(port) + mirrorPath
+}
+
+// MavenSettingsConfigMap returns the ConfigMap with the Maven settings mirroring every repository
+// through the node dfdaemon, whose host Maven expands from the env var. The webhook keeps it up to date in the namespaces of the pods using it.
+// The settings are shared by the pods of the namespace, so only the IP family of the admission, which may be nil,
+// is used, the host set for the pod, e.g. its node IP or the hostNetwork proxy host, is ignored.
+func MavenSettingsConfigMap(config *InjectConf, admission *PodAdmission) *corev1.ConfigMap {
+	var shared PodAdmission
+	if admission != nil {
+		shared.hostIPFamily = admission.hostIPFamily
+	}
+	host := dfdaemonHost(config, &shared, func(name string) string { return "${env." + name + "}" })
+	url := config.EcosystemMirrors.url(config, host, config.EcosystemMirrors.Maven)
+	settings := `<settings xmlns="http://maven.apache.org/SETTINGS/1.0.0">
+  <mirrors>
+    <mirror>
+      <id>dragonfly</id>
+      <mirrorOf>*</mirrorOf>
+      <url>` + url + `</url>
+    </mirror>
+  </mirrors>
+</settings>
+`
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: MavenSettingsConfigMapName},
+		Data:       map[string]string{MavenSettingsFileName: settings},
+	}
+}
+
+// MirrorsForPod returns the ecosystems enabled by the pod annotation that have a mirror, and a warning
+// about the ecosystems that don't.
+func MirrorsForPod(config *InjectConf, pod *corev1.Pod) ([]string, string) {
+	value, ok := pod.GetAnnotations()[MirrorsAnnotation]
+	if !ok || config.EcosystemMirrors == nil {
+		return nil, ""
+	}
+	paths := config.EcosystemMirrors.paths()
+	var ecosystems, unknown []string
+	for _, ecosystem := range strings.Split(value, ",") {
+		ecosystem = strings.TrimSpace(ecosystem)
+		if ecosystem == "" || slices.Contains(ecosystems, ecosystem) {
+			continue
+		}
+		if paths[ecosystem] == "" {
+			unknown = append(unknown, ecosystem)
+			continue
+		}
+		ecosystems = append(ecosystems, ecosystem)
+	}
+	if len(unknown) != 0 {
+		return ecosystems, fmt.Sprintf("ignore ecosystems without a mirror in annotation %s: %s",
+			MirrorsAnnotation, strings.Join(unknown, ","))
+	}
+	return ecosystems, ""
+}
+
+type MirrorsInjector struct{}
+
+func NewMirrorsInjector() *MirrorsInjector {
+	return &MirrorsInjector{}
+}
+
+func (mi *MirrorsInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
+	ecosystems, _ := MirrorsForPod(config, pod)
+	if len(ecosystems) == 0 {
+		return
+	}
+	podlog.Info("MirrorsInjector Inject", "ecosystems", ecosystems)
+
+	mirrors := config.EcosystemMirrors
+	hostRef := dfdaemonHost(config, admission, kubeletEnvRef)
+	// the node is referenced by the mirror URLs, it is defined first unless the container has it
+	envs := dfdaemonHostEnvs(config)
+	for _, ecosystem := range ecosystems {
+		url := mirrors.url(config, hostRef, mirrors.paths()[ecosystem])
+		switch ecosystem {
+		case MirrorEcosystemPip:
+			envs = append(envs,
+				corev1.EnvVar{Name: "PIP_INDEX_URL", Value: url},
+				corev1.EnvVar{Name: "PIP_TRUSTED_HOST", Value: hostRef})
+		case MirrorEcosystemNpm:
+			envs = append(envs, corev1.EnvVar{Name: "npm_config_registry", Value: url})
+		case MirrorEcosystemGo:
+			envs = append(envs, corev1.EnvVar{Name: "GOPROXY", Value: url})
+		case MirrorEcosystemHuggingFace:
+			envs = append(envs, corev1.EnvVar{Name: "HF_ENDPOINT", Value: url})
+		case MirrorEcosystemMaven:
+			settingsFile := path.Join(MavenSettingsDirPath, MavenSettingsFileName)
+			envs = append(envs, corev1.EnvVar{Name: "MAVEN_ARGS", Value: "--settings " + settingsFile})
+			addVolume(config, admission, pod, corev1.Volume{
+				Name: MavenSettingsVolumeName,
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: MavenSettingsConfigMapName},
+				}},
+			})
+			for i := range pod.Spec.Containers {
+				addVolumeMount(config, admission, &pod.Spec.Containers[i], corev1.VolumeMount{
+					Name:      MavenSettingsVolumeName,
+					MountPath: MavenSettingsDirPath,
+					ReadOnly:  true,
+				})
+			}
+		}
+	}
+	for i := range pod.Spec.Containers {
+		injectContainer(config, admission, &pod.Spec.Containers[i], envs)
+	}
+}
+
+// Admit warns about ecosystems of the pod annotation that have no mirror.
+func (mi *MirrorsInjector) Admit(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) ([]string, error) {
+	if _, warning := MirrorsForPod(config, pod); warning != "" {
+		return []string{warning}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,118 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("MirrorsInjector", func() {
+	var (
+		config *InjectConf
+		pod    *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		config = NewDefaultInjectConf()
+		config.ProxyPort = 4001
+		config.EcosystemMirrors = &EcosystemMirrors{
+			Port:        65001,
+			Pip:         "/pypi/simple",
+			Npm:         "/npm/",
+			Go:          "/goproxy",
+			Maven:       "/maven2",
+			HuggingFace: "/huggingface",
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Annotations: map[string]string{}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}}},
+		}
+	})
+
+	It("should not inject mirrors without the annotation", func() {
+		NewMirrorsInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+	})
+
+	It("should point the annotated ecosystems to the node dfdaemon", func() {
+		pod.Annotations[MirrorsAnnotation] = "pip, npm,go,huggingface"
+		NewMirrorsInjector().Inject(pod, config, nil)
+
+		for _, c := range pod.Spec.Containers {
+			Expect(c.Env).To(Equal([]corev1.EnvVar{
+				{
+					Name:      NodeNameEnvName,
+					ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+				},
+				{Name: "PIP_INDEX_URL", Value: "http://$(NODE_NAME):65001/pypi/simple"},
+				{Name: "PIP_TRUSTED_HOST", Value: "$(NODE_NAME)"},
+				{Name: "npm_config_registry", Value: "http://$(NODE_NAME):65001/npm/"},
+				{Name: "GOPROXY", Value: "http://$(NODE_NAME):65001/goproxy"},
+				{Name: "HF_ENDPOINT", Value: "http://$(NODE_NAME):65001/huggingface"},
+			}))
+		}
+		Expect(pod.Spec.Volumes).To(BeEmpty())
+	})
+
+	It("should mount the maven settings", func() {
+		pod.Annotations[MirrorsAnnotation] = "maven"
+		NewMirrorsInjector().Inject(pod, config, nil)
+
+		Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+			Name: MavenSettingsVolumeName,
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: MavenSettingsConfigMapName},
+			}},
+		}))
+		for _, c := range pod.Spec.Containers {
+			Expect(c.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+				Name: MavenSettingsVolumeName, MountPath: MavenSettingsDirPath, ReadOnly: true,
+			}))
+			Expect(c.Env).To(ContainElement(corev1.EnvVar{Name: "MAVEN_ARGS", Value: "--settings /etc/dragonfly/maven/settings.xml"}))
+		}
+
+		settings := MavenSettingsConfigMap(config, nil).Data[MavenSettingsFileName]
+		Expect(settings).To(ContainSubstring("<mirrorOf>*</mirrorOf>"))
+		Expect(settings).To(ContainSubstring("<url>http://${env.NODE_NAME}:65001/maven2</url>"))
+	})
+
+	It("should not write the host of the pod into the shared maven settings", func() {
+		config.ProxyHost = ProxyHostHostIP
+		settings := MavenSettingsConfigMap(config, &PodAdmission{proxyHost: "10.0.0.1"}).Data[MavenSettingsFileName]
+		Expect(settings).To(ContainSubstring("<url>http://${env.DRAGONFLY_HOST_IP}:65001/maven2</url>"))
+	})
+
+	It("should not write the host of hostNetwork pods into the shared maven settings", func() {
+		config.HostNetwork = &HostNetworkProfile{}
+		pod.Spec.HostNetwork = true
+		effective := EffectiveConfig(config, pod, nil)
+		settings := MavenSettingsConfigMap(effective, NewPodAdmission(effective, pod, nil)).Data[MavenSettingsFileName]
+		Expect(settings).To(ContainSubstring("<url>http://${env.NODE_NAME}:65001/maven2</url>"))
+		Expect(settings).NotTo(ContainSubstring(DefaultHostNetworkProxyHost))
+	})
+
+	It("should use the proxy port by default", func() {
+		config.EcosystemMirrors.Port = 0
+		pod.Annotations[MirrorsAnnotation] = "go"
+		NewMirrorsInjector().Inject(pod, config, nil)
+		Expect(pod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "GOPROXY", Value: "http://$(NODE_NAME):4001/goproxy"}))
+	})
+
+	It("should warn about ecosystems without a mirror", func() {
+		config.EcosystemMirrors.Npm = ""
+		pod.Annotations[MirrorsAnnotation] = "pip,npm,cargo"
+		warnings, err := NewMirrorsInjector().Admit(pod, config, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ConsistOf(ContainSubstring("npm,cargo")))
+
+		ecosystems, _ := MirrorsForPod(config, pod)
+		Expect(ecosystems).To(Equal([]string{MirrorEcosystemPip}))
+	})
+
+	It("should validate the mirror paths", func() {
+		Expect(config.validate()).To(Succeed())
+		config.EcosystemMirrors.Go = "goproxy"
+		Expect(config.validate()).To(MatchError(ContainSubstring(`go mirror path "goproxy" must be absolute`)))
+	})
+})
@@ -0,0 +1,124 @@
+package injector
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// Pod Security Standards namespace label, see https://kubernetes.io/docs/concepts/security/pod-security-admission/
+	PodSecurityEnforceLabelName string = "pod-security.kubernetes.io/enforce"
+	PodSecurityLevelPrivileged  string = "privileged"
+	PodSecurityLevelBaseline    string = "baseline"
+	PodSecurityLevelRestricted  string = "restricted"
+
+	// CliToolsRunAsUser is the non-root UID the cli tools initContainer runs as in restricted namespaces,
+	// unless its security context sets another one.
+	CliToolsRunAsUser int64 = 65532
+)
+
+// baselineCapabilities are the capabilities the baseline level allows containers to add.
+var baselineCapabilities = []corev1.Capability{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+	"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// restrictedCapabilities are the capabilities the restricted level allows containers to add.
+var restrictedCapabilities = []corev1.Capability{"NET_BIND_SERVICE"}
+
+// NewRestrictedCliToolsSecurityContext returns the security context of the cli tools initContainer enabled by
+// InjectConf.CliToolsRestrictedSecurityContext. It complies with the restricted Pod Security Standard.
+func NewRestrictedCliToolsSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		RunAsUser:                ptr.To(CliToolsRunAsUser),
+		AllowPrivilegeEscalation: ptr.To(false),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// namespacePodSecurityLevel returns the enforced Pod Security Standard level of the namespace, the
+// configured default level if the namespace doesn't set it.
+func namespacePodSecurityLevel(config *InjectConf, nsInfo *NamespaceInfo) string {
+	var level string
+	if nsInfo != nil && nsInfo.Namespace != nil {
+		level = nsInfo.Namespace.GetLabels()[PodSecurityEnforceLabelName]
+	}
+	switch level {
+	case PodSecurityLevelPrivileged, PodSecurityLevelBaseline, PodSecurityLevelRestricted:
+		return level
+	}
+	switch config.PodSecurityDefaultLevel {
+	case PodSecurityLevelBaseline, PodSecurityLevelRestricted:
+		return config.PodSecurityDefaultLevel
+	default:
+		return PodSecurityLevelPrivileged
+	}
+}
+
+// podSecurityCompliant returns a copy of the security context adjusted to the given Pod Security
+// Standard level, and reports whether anything had to be changed.
+func podSecurityCompliant(sc *corev1.SecurityContext, level string) (*corev1.SecurityContext, bool) {
+	if level == PodSecurityLevelPrivileged {
+		return sc, false
+	}
+
+	compliant := sc.DeepCopy()
+	if compliant == nil {
+		compliant = &corev1.SecurityContext{}
+	}
+
+	// baseline
+	if compliant.Privileged != nil && *compliant.Privileged {
+		compliant.Privileged = ptr.To(false)
+	}
+	if compliant.SeccompProfile != nil && compliant.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		compliant.SeccompProfile = nil
+	}
+	allowedCapabilities := baselineCapabilities
+
+	if level == PodSecurityLevelRestricted {
+		allowedCapabilities = restrictedCapabilities
+		compliant.AllowPrivilegeEscalation = ptr.To(false)
+		compliant.RunAsNonRoot = ptr.To(true)
+		// the image may run as root, which runAsNonRoot rejects
+		if compliant.RunAsUser == nil || *compliant.RunAsUser == 0 {
+			compliant.RunAsUser = ptr.To(CliToolsRunAsUser)
+		}
+		if compliant.SeccompProfile == nil {
+			compliant.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+		}
+		if compliant.Capabilities == nil {
+			compliant.Capabilities = &corev1.Capabilities{}
+		}
+		if !slices.Contains(compliant.Capabilities.Drop, "ALL") {
+			compliant.Capabilities.Drop = append(compliant.Capabilities.Drop, "ALL")
+		}
+	}
+	if compliant.Capabilities != nil && len(compliant.Capabilities.Add) > 0 {
+		compliant.Capabilities.Add = slices.DeleteFunc(compliant.Capabilities.Add, func(c corev1.Capability) bool {
+			return !slices.Contains(allowedCapabilities, c)
+		})
+		if len(compliant.Capabilities.Add) == 0 {
+			compliant.Capabilities.Add = nil
+		}
+	}
+
+	original := sc
+	if original == nil {
+		original = &corev1.SecurityContext{}
+	}
+	if equality.Semantic.DeepEqual(original, compliant) {
+		return sc, false
+	}
+	return compliant, true
+}
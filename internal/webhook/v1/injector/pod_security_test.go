@@ -0,0 +1,141 @@
+package injector
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("Pod security", func() {
+	makeNsInfo := func(level string) *NamespaceInfo {
+		return &NamespaceInfo{Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-namespace",
+			Labels: map[string]string{PodSecurityEnforceLabelName: level},
+		}}}
+	}
+
+	Describe("namespacePodSecurityLevel", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = NewDefaultInjectConf()
+		})
+
+		It("should read the enforce label", func() {
+			Expect(namespacePodSecurityLevel(config, makeNsInfo(PodSecurityLevelRestricted))).To(Equal(PodSecurityLevelRestricted))
+			Expect(namespacePodSecurityLevel(config, makeNsInfo(PodSecurityLevelBaseline))).To(Equal(PodSecurityLevelBaseline))
+		})
+
+		It("should default to privileged", func() {
+			Expect(namespacePodSecurityLevel(config, nil)).To(Equal(PodSecurityLevelPrivileged))
+			Expect(namespacePodSecurityLevel(config, &NamespaceInfo{})).To(Equal(PodSecurityLevelPrivileged))
+			Expect(namespacePodSecurityLevel(config, makeNsInfo("unknown"))).To(Equal(PodSecurityLevelPrivileged))
+		})
+
+		It("should default to the configured level of the cluster", func() {
+			config.PodSecurityDefaultLevel = PodSecurityLevelRestricted
+			Expect(namespacePodSecurityLevel(config, &NamespaceInfo{})).To(Equal(PodSecurityLevelRestricted))
+			Expect(namespacePodSecurityLevel(config, makeNsInfo(PodSecurityLevelPrivileged))).To(Equal(PodSecurityLevelPrivileged))
+			Expect(namespacePodSecurityLevel(config, makeNsInfo(PodSecurityLevelBaseline))).To(Equal(PodSecurityLevelBaseline))
+		})
+
+		It("should validate the default level", func() {
+			config.PodSecurityDefaultLevel = "strict"
+			Expect(config.validate()).To(MatchError(ContainSubstring("invalid pod security default level")))
+		})
+	})
+
+	Describe("podSecurityCompliant", func() {
+		It("should keep the restricted security context in restricted namespaces", func() {
+			sc := NewRestrictedCliToolsSecurityContext()
+			compliant, changed := podSecurityCompliant(sc, PodSecurityLevelRestricted)
+			Expect(changed).To(BeFalse())
+			Expect(compliant).To(BeIdenticalTo(sc))
+		})
+
+		It("should never change anything in privileged namespaces", func() {
+			sc := &corev1.SecurityContext{Privileged: ptr.To(true)}
+			compliant, changed := podSecurityCompliant(sc, PodSecurityLevelPrivileged)
+			Expect(changed).To(BeFalse())
+			Expect(compliant).To(BeIdenticalTo(sc))
+		})
+
+		It("should remove privileges forbidden by the baseline level", func() {
+			sc := &corev1.SecurityContext{
+				Privileged:     ptr.To(true),
+				Capabilities:   &corev1.Capabilities{Add: []corev1.Capability{"CHOWN", "SYS_ADMIN"}},
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+			}
+			compliant, changed := podSecurityCompliant(sc, PodSecurityLevelBaseline)
+			Expect(changed).To(BeTrue())
+			Expect(compliant).To(Equal(&corev1.SecurityContext{
+				Privileged:   ptr.To(false),
+				Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"CHOWN"}},
+			}))
+			Expect(sc.Capabilities.Add).To(HaveLen(2))
+		})
+
+		It("should create a security context for the restricted level", func() {
+			compliant, changed := podSecurityCompliant(nil, PodSecurityLevelRestricted)
+			Expect(changed).To(BeTrue())
+			Expect(compliant.RunAsNonRoot).To(Equal(ptr.To(true)))
+			Expect(compliant.RunAsUser).To(Equal(ptr.To(CliToolsRunAsUser)))
+
+			compliant, changed = podSecurityCompliant(nil, PodSecurityLevelBaseline)
+			Expect(changed).To(BeFalse())
+			Expect(compliant).To(BeNil())
+		})
+
+		It("should complete an empty security context for the restricted level", func() {
+			compliant, changed := podSecurityCompliant(&corev1.SecurityContext{RunAsUser: ptr.To(int64(0))}, PodSecurityLevelRestricted)
+			Expect(changed).To(BeTrue())
+			Expect(compliant).To(Equal(&corev1.SecurityContext{
+				RunAsNonRoot:             ptr.To(true),
+				RunAsUser:                ptr.To(CliToolsRunAsUser),
+				AllowPrivilegeEscalation: ptr.To(false),
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			}))
+		})
+	})
+
+	Describe("EffectiveConfig", func() {
+		var (
+			config *InjectConf
+			pod    *corev1.Pod
+		)
+
+		BeforeEach(func() {
+			config = NewDefaultInjectConf()
+			config.CliToolsSecurityContext = &corev1.SecurityContext{}
+			pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-namespace"}}
+		})
+
+		It("should make the cli tools and the socket comply with restricted namespaces", func() {
+			effective := EffectiveConfig(config, pod, makeNsInfo(PodSecurityLevelRestricted))
+			Expect(effective.DisableUnixSocket).To(BeTrue())
+			Expect(effective.CliToolsSecurityContext.RunAsNonRoot).To(Equal(ptr.To(true)))
+			Expect(config.CliToolsSecurityContext).To(Equal(&corev1.SecurityContext{}))
+		})
+
+		It("should skip the socket in baseline namespaces", func() {
+			effective := EffectiveConfig(config, pod, makeNsInfo(PodSecurityLevelBaseline))
+			Expect(effective.DisableUnixSocket).To(BeTrue())
+			Expect(effective.CliToolsSecurityContext).To(Equal(&corev1.SecurityContext{}))
+		})
+
+		It("should apply the default level of the cluster to unlabeled namespaces", func() {
+			config.PodSecurityDefaultLevel = PodSecurityLevelBaseline
+			effective := EffectiveConfig(config, pod, &NamespaceInfo{Namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-namespace"},
+			}})
+			Expect(effective.DisableUnixSocket).To(BeTrue())
+		})
+
+		It("should keep the config in privileged namespaces", func() {
+			Expect(EffectiveConfig(config, pod, makeNsInfo(PodSecurityLevelPrivileged))).To(BeIdenticalTo(config))
+		})
+	})
+})
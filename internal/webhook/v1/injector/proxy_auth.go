@@ -0,0 +1,96 @@
+package injector
+
+import (
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ProxyAuth is the basic auth of the dfdaemon proxy. The credentials are injected from the Secret with
+// secretKeyRef env vars and composed into the proxy URL by the kubelet, so they never appear in the pod
+// spec. They are inserted into the URL as is and must not contain URL reserved characters.
+type ProxyAuth struct {
+	// Secret holding the credentials, copied into the pod namespace when in another namespace,
+	// the pod namespace when SecretNamespace is unset
+	SecretNamespace string `yaml:"secretNamespace,omitempty" json:"secretNamespace,omitempty"`
+	SecretName      string `yaml:"secretName" json:"secretName"`
+	// Keys of the credentials in the Secret, those of kubernetes.io/basic-auth Secrets when unset
+	UsernameKey string `yaml:"usernameKey,omitempty" json:"usernameKey,omitempty"`
+	PasswordKey string `yaml:"passwordKey,omitempty" json:"passwordKey,omitempty"`
+	// Keys of the percent-encoded credentials in the Secret, composed into the proxy URL instead of the
+	// credentials, which are then inserted as is and must not contain URL reserved characters
+	EncodedUsernameKey string `yaml:"encodedUsernameKey,omitempty" json:"encodedUsernameKey,omitempty"`
+	EncodedPasswordKey string `yaml:"encodedPasswordKey,omitempty" json:"encodedPasswordKey,omitempty"`
+}
+
+func (a *ProxyAuth) validate() error {
+	if a.SecretName == "" {
+		return errors.New("proxy auth requires a secretName")
+	}
+	return nil
+}
+
+func (a *ProxyAuth) usernameKey() string {
+	if a.UsernameKey != "" {
+		return a.UsernameKey
+	}
+	return corev1.BasicAuthUsernameKey
+}
+
+func (a *ProxyAuth) passwordKey() string {
+	if a.PasswordKey != "" {
+		return a.PasswordKey
+	}
+	return corev1.BasicAuthPasswordKey
+}
+
+// envs returns the env vars referencing the credentials in the Secret.
+func (a *ProxyAuth) envs() []corev1.EnvVar {
+	secretKeyRef := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: a.SecretName},
+			Key:                  key,
+		}}
+	}
+	envs := []corev1.EnvVar{
+		{Name: ProxyUsernameEnvName, ValueFrom: secretKeyRef(a.usernameKey())},
+		{Name: ProxyPasswordEnvName, ValueFrom: secretKeyRef(a.passwordKey())},
+	}
+	if a.EncodedUsernameKey != "" {
+		envs = append(envs, corev1.EnvVar{Name: ProxyEncodedUsernameEnvName, ValueFrom: secretKeyRef(a.EncodedUsernameKey)})
+	}
+	if a.EncodedPasswordKey != "" {
+		envs = append(envs, corev1.EnvVar{Name: ProxyEncodedPasswordEnvName, ValueFrom: secretKeyRef(a.EncodedPasswordKey)})
+	}
+	return envs
+}
+
+// userinfo returns the userinfo of the proxy URL, referencing the encoded credentials when the Secret has them.
+func (a *ProxyAuth) userinfo() string {
+	username, password := ProxyUsernameEnvName, ProxyPasswordEnvName
+	if a.EncodedUsernameKey != "" {
+		username = ProxyEncodedUsernameEnvName
+	}
+	if a.EncodedPasswordKey != "" {
+		password = ProxyEncodedPasswordEnvName
+	}
+	return kubeletEnvRef(username) + ":" + kubeletEnvRef(password) + "@"
+}
+
+// proxyAuthForNamespace returns the proxy auth of the namespace annotation, which names a Secret of the
+// namespace and takes precedence over the config. It reports false if the namespace has no annotation.
+func proxyAuthForNamespace(config *InjectConf, nsInfo *NamespaceInfo) (*ProxyAuth, bool) {
+	if nsInfo == nil || nsInfo.Namespace == nil {
+		return nil, false
+	}
+	name := nsInfo.Namespace.GetAnnotations()[ProxyAuthSecretAnnotation]
+	if name == "" {
+		return nil, false
+	}
+	auth := &ProxyAuth{SecretName: name}
+	if config.ProxyAuth != nil {
+		auth.UsernameKey, auth.PasswordKey = config.ProxyAuth.UsernameKey, config.ProxyAuth.PasswordKey
+		auth.EncodedUsernameKey, auth.EncodedPasswordKey = config.ProxyAuth.EncodedUsernameKey, config.ProxyAuth.EncodedPasswordKey
+	}
+	return auth, true
+}
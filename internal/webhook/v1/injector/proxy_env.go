@@ -15,50 +15,46 @@ func NewProxyEnvInjector() *ProxyEnvInjector {
 	return &ProxyEnvInjector{}
 }
 
-func (pei *ProxyEnvInjector) Inject(pod *corev1.Pod, config *InjectConf) {
+func (pei *ProxyEnvInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
 	podlog.Info("ProxyEnvInjector Inject")
 
-	envs := envsFromConfig(config)
+	envs := config.proxyEnvs(admission)
 	// inject env to all containers
 	containers := pod.Spec.Containers
 	for i := range containers {
-		injectContainer(&containers[i], envs)
+		injectContainer(config, admission, &containers[i], envs)
 	}
 }
 
-func envsFromConfig(config *InjectConf) []corev1.EnvVar {
-	envs := []corev1.EnvVar{
-		{
-			Name: NodeNameEnvName,
-			ValueFrom: &corev1.EnvVarSource{
-				FieldRef: &corev1.ObjectFieldSelector{
-					FieldPath: "spec.nodeName",
-				},
-			},
-		},
+// Admit warns when the pod can't reach the dfdaemon at the IP family of the config.
+func (pei *ProxyEnvInjector) Admit(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) ([]string, error) {
+	if warning := hostIPFamilyWarning(config, admission); warning != "" {
+		return []string{warning}, nil
+	}
+	return nil, nil
+}
+
+func envsFromConfig(config *InjectConf, admission *PodAdmission) []corev1.EnvVar {
+	// the credentials are referenced before the proxy URL, so the kubelet can expand them
+	userinfo := ""
+	var authEnvs []corev1.EnvVar
+	if config.ProxyAuth != nil {
+		authEnvs = config.ProxyAuth.envs()
+		userinfo = config.ProxyAuth.userinfo()
+	}
+	envs := append(authEnvs, dfdaemonHostEnvs(config)...)
+	envs = append(envs, []corev1.EnvVar{
 		{
 			Name:  ProxyPortEnvName,
 			Value: strconv.Itoa(config.ProxyPort),
 		},
 		{
 			Name:  ProxyEnvName,
-			Value: "http://$(" + NodeNameEnvName + "):$(" + ProxyPortEnvName + ")",
+			Value: "http://" + userinfo + dfdaemonHost(config, admission, kubeletEnvRef) + ":$(" + ProxyPortEnvName + ")",
 		},
+	}...)
+	if endpoint := dfdaemonEndpoint(config); endpoint != "" {
+		envs = append(envs, corev1.EnvVar{Name: DfdaemonEndpointEnvName, Value: endpoint})
 	}
 	return envs
 }
-func injectContainer(c *corev1.Container, envs []corev1.EnvVar) {
-	for _, e := range envs {
-		exsit := false
-		// if env exsit, skip
-		for _, ce := range c.Env {
-			if e.Name == ce.Name {
-				exsit = true
-				break
-			}
-		}
-		if !exsit {
-			c.Env = append(c.Env, e)
-		}
-	}
-}
@@ -32,7 +32,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, config)
+			injector.Inject(pod, config, nil)
 
 			By("verifying the injected environment variables")
 			Expect(pod.Spec.Containers).To(HaveLen(1))
@@ -76,7 +76,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, config)
+			injector.Inject(pod, config, nil)
 
 			By("verifying the original value is preserved")
 			Expect(pod.Spec.Containers).To(HaveLen(1))
@@ -120,7 +120,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, config)
+			injector.Inject(pod, config, nil)
 
 			By("verifying all containers have proxy environment variables")
 			Expect(pod.Spec.Containers).To(HaveLen(2))
@@ -156,7 +156,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, config)
+			injector.Inject(pod, config, nil)
 
 			By("verifying no containers were added")
 			Expect(pod.Spec.Containers).To(BeEmpty())
@@ -182,7 +182,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, config)
+			injector.Inject(pod, config, nil)
 
 			By("verifying the pod remains completely unchanged")
 			Expect(pod.Spec.Containers).To(HaveLen(1))
@@ -201,7 +201,7 @@ var _ = Describe("ProxyEnvInjector", func() {
 			config := &InjectConf{ProxyPort: 8080}
 
 			By("generating environment variables")
-			envs := envsFromConfig(config)
+			envs := envsFromConfig(config, nil)
 
 			By("verifying the generated environment variables")
 			Expect(envs).To(Equal([]corev1.EnvVar{
@@ -224,4 +224,266 @@ var _ = Describe("ProxyEnvInjector", func() {
 			}))
 		})
 	})
+
+	Context("when the proxy requires basic auth", func() {
+		It("should compose the proxy URL from secretKeyRef env vars", func() {
+			config := &InjectConf{ProxyPort: 8080, ProxyAuth: &ProxyAuth{SecretName: "proxy-auth"}}
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+			injector.Inject(pod, config, nil)
+
+			env := pod.Spec.Containers[0].Env
+			Expect(env[:2]).To(Equal([]corev1.EnvVar{
+				{Name: ProxyUsernameEnvName, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-auth"}, Key: corev1.BasicAuthUsernameKey,
+				}}},
+				{Name: ProxyPasswordEnvName, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-auth"}, Key: corev1.BasicAuthPasswordKey,
+				}}},
+			}))
+			Expect(env).To(ContainElement(corev1.EnvVar{
+				Name: ProxyEnvName,
+				Value: fmt.Sprintf("http://$(%s):$(%s)@$(%s):$(%s)",
+					ProxyUsernameEnvName, ProxyPasswordEnvName, NodeNameEnvName, ProxyPortEnvName),
+			}))
+
+			By("never injecting literal credentials")
+			for _, e := range env[:2] {
+				Expect(e.Value).To(BeEmpty())
+			}
+		})
+
+		It("should use the configured secret keys", func() {
+			config := &InjectConf{ProxyAuth: &ProxyAuth{SecretName: "proxy-auth", UsernameKey: "user", PasswordKey: "token"}}
+			envs := envsFromConfig(config, nil)
+			Expect(envs[0].ValueFrom.SecretKeyRef.Key).To(Equal("user"))
+			Expect(envs[1].ValueFrom.SecretKeyRef.Key).To(Equal("token"))
+		})
+
+		It("should compose the encoded credentials into the proxy URL", func() {
+			config := &InjectConf{ProxyPort: 4001, ProxyAuth: &ProxyAuth{SecretName: "proxy-auth", EncodedPasswordKey: "password.url"}}
+			envs := envsFromConfig(config, nil)
+			Expect(envs).To(ContainElement(corev1.EnvVar{
+				Name: ProxyEncodedPasswordEnvName,
+				ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-auth"}, Key: "password.url",
+				}},
+			}))
+			Expect(envs).To(ContainElement(corev1.EnvVar{
+				Name: ProxyEnvName,
+				Value: "http://$(" + ProxyUsernameEnvName + "):$(" + ProxyEncodedPasswordEnvName + ")@$(" +
+					NodeNameEnvName + "):$(" + ProxyPortEnvName + ")",
+			}))
+		})
+
+		It("should use the secret named by the namespace annotation", func() {
+			config := NewDefaultInjectConf()
+			config.ProxyAuth = &ProxyAuth{SecretNamespace: "dragonfly-system", SecretName: "proxy-auth", UsernameKey: "user"}
+			config.compile()
+			nsInfo := &NamespaceInfo{Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "app",
+				Annotations: map[string]string{ProxyAuthSecretAnnotation: "team-proxy-auth"},
+			}}}
+
+			effective := EffectiveConfig(config, &corev1.Pod{}, nsInfo)
+			Expect(effective.ProxyAuth).To(Equal(&ProxyAuth{SecretName: "team-proxy-auth", UsernameKey: "user"}))
+			Expect(effective.proxyEnvs(nil)[0].ValueFrom.SecretKeyRef.Name).To(Equal("team-proxy-auth"))
+			Expect(config.ProxyAuth.SecretName).To(Equal("proxy-auth"))
+		})
+	})
+
+	Context("when pods reach the dfdaemon through a Service", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				ProxyPort:    4001,
+				ProxyMode:    ProxyModeService,
+				ProxyService: &ProxyService{Namespace: "dragonfly-system", Name: "dfdaemon", GRPCPort: 4000},
+			}
+		})
+
+		It("should use the Service in the proxy URL", func() {
+			envs := envsFromConfig(config, nil)
+			Expect(envs).To(ContainElement(corev1.EnvVar{
+				Name:  ProxyEnvName,
+				Value: "http://dfdaemon.dragonfly-system.svc:$(" + ProxyPortEnvName + ")",
+			}))
+			Expect(envs).NotTo(ContainElement(HaveField("Name", DfdaemonEndpointEnvName)))
+		})
+
+		It("should inject the dfdaemon endpoint into pods without the unix socket", func() {
+			config.DisableUnixSocket = true
+			Expect(envsFromConfig(config, nil)).To(ContainElement(corev1.EnvVar{
+				Name:  DfdaemonEndpointEnvName,
+				Value: "http://dfdaemon.dragonfly-system.svc:4000",
+			}))
+
+			By("not injecting it without the gRPC port")
+			config.ProxyService.GRPCPort = 0
+			Expect(envsFromConfig(config, nil)).NotTo(ContainElement(HaveField("Name", DfdaemonEndpointEnvName)))
+		})
+
+		It("should inject the endpoint when the namespace forbids the unix socket", func() {
+			config.ProxyService.GRPCPort = 4000
+			config.compile()
+			nsInfo := &NamespaceInfo{Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{PodSecurityEnforceLabelName: PodSecurityLevelRestricted},
+			}}}
+			effective := EffectiveConfig(config, &corev1.Pod{}, nsInfo)
+			Expect(effective.proxyEnvs(nil)).To(ContainElement(HaveField("Name", DfdaemonEndpointEnvName)))
+		})
+
+		It("should use the Service for the mirrors", func() {
+			config.EcosystemMirrors = &EcosystemMirrors{Go: "/goproxy", Maven: "/maven2"}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{MirrorsAnnotation: "go"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			NewMirrorsInjector().Inject(pod, config, nil)
+			Expect(pod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+				Name: "GOPROXY", Value: "http://dfdaemon.dragonfly-system.svc:4001/goproxy",
+			}))
+			Expect(MavenSettingsConfigMap(config, nil).Data[MavenSettingsFileName]).To(
+				ContainSubstring("<url>http://dfdaemon.dragonfly-system.svc:4001/maven2</url>"))
+		})
+
+		It("should validate the proxy mode", func() {
+			Expect(validateProxyMode(config)).To(Succeed())
+			config.ProxyService = nil
+			Expect(validateProxyMode(config)).To(MatchError(ContainSubstring("requires the proxyService")))
+			config.ProxyMode = "Host"
+			Expect(validateProxyMode(config)).To(MatchError(ContainSubstring("invalid proxy mode")))
+		})
+	})
+
+	Context("when pods reach the dfdaemon at the node IP", func() {
+		var config *InjectConf
+
+		node := func(addresses ...string) *NamespaceInfo {
+			n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+			for _, address := range addresses {
+				n.Status.Addresses = append(n.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: address})
+			}
+			n.Status.Addresses = append(n.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeHostName, Address: "node-1"})
+			return &NamespaceInfo{Node: n}
+		}
+		boundPod := func() *corev1.Pod {
+			return &corev1.Pod{Spec: corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "app"}}}}
+		}
+		proxyURL := func(pod *corev1.Pod, nsInfo *NamespaceInfo) string {
+			effective := EffectiveConfig(config, pod, nsInfo)
+			for _, env := range effective.proxyEnvs(NewPodAdmission(effective, pod, nsInfo)) {
+				if env.Name == ProxyEnvName {
+					return env.Value
+				}
+			}
+			return ""
+		}
+
+		BeforeEach(func() {
+			config = &InjectConf{ProxyPort: 4001, ProxyHost: ProxyHostHostIP}
+		})
+
+		It("should reference the host IPs of the pod", func() {
+			envs := envsFromConfig(config, nil)
+			Expect(envs).To(ContainElements(
+				corev1.EnvVar{Name: HostIPEnvName, ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"},
+				}},
+				corev1.EnvVar{Name: HostIPsEnvName, ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIPs"},
+				}},
+				corev1.EnvVar{Name: ProxyEnvName, Value: "http://$(" + HostIPEnvName + "):$(" + ProxyPortEnvName + ")"},
+			))
+
+			By("enclosing the IPv6 host IP in brackets")
+			config.ProxyIPFamily = corev1.IPv6Protocol
+			Expect(envsFromConfig(config, nil)).To(ContainElement(corev1.EnvVar{
+				Name: ProxyEnvName, Value: "http://[$(" + HostIPEnvName + ")]:$(" + ProxyPortEnvName + ")",
+			}))
+		})
+
+		It("should use the IP of an IPv4 node", func() {
+			Expect(proxyURL(boundPod(), node("10.0.0.1"))).To(Equal("http://10.0.0.1:$(" + ProxyPortEnvName + ")"))
+		})
+
+		It("should use the IP of an IPv6 node in brackets", func() {
+			Expect(proxyURL(boundPod(), node("fd00::1"))).To(Equal("http://[fd00::1]:$(" + ProxyPortEnvName + ")"))
+		})
+
+		It("should use the IP of the preferred family of a dual-stack node", func() {
+			nsInfo := node("fd00::1", "10.0.0.1")
+			Expect(proxyURL(boundPod(), nsInfo)).To(Equal("http://10.0.0.1:$(" + ProxyPortEnvName + ")"))
+
+			config.ProxyIPFamily = corev1.IPv6Protocol
+			Expect(proxyURL(boundPod(), nsInfo)).To(Equal("http://[fd00::1]:$(" + ProxyPortEnvName + ")"))
+		})
+
+		It("should reference the host IP of pods that are not bound to the node", func() {
+			pod := boundPod()
+			pod.Spec.NodeName = ""
+			Expect(EffectiveConfig(config, pod, node("10.0.0.1"))).To(BeIdenticalTo(config))
+			Expect(proxyURL(pod, node("10.0.0.1"))).To(Equal("http://$(" + HostIPEnvName + "):$(" + ProxyPortEnvName + ")"))
+		})
+
+		Context("with pods that are not bound to the node", func() {
+			var pod *corev1.Pod
+
+			BeforeEach(func() {
+				pod = boundPod()
+				pod.Spec.NodeName = ""
+			})
+
+			It("should bracket the host IP on IPv6-only clusters", func() {
+				nsInfo := &NamespaceInfo{HostIPFamily: NodeHostIPFamily(node("fd00::1").Node)}
+				Expect(proxyURL(pod, nsInfo)).To(Equal("http://[$(" + HostIPEnvName + ")]:$(" + ProxyPortEnvName + ")"))
+				Expect(NewProxyEnvInjector().Admit(pod, config, NewPodAdmission(config, pod, nsInfo))).To(BeEmpty())
+			})
+
+			It("should follow the primary family of dual-stack clusters with a warning", func() {
+				config.ProxyIPFamily = corev1.IPv6Protocol
+				nsInfo := &NamespaceInfo{HostIPFamily: NodeHostIPFamily(node("10.0.0.1", "fd00::1").Node)}
+				Expect(proxyURL(pod, nsInfo)).To(Equal("http://$(" + HostIPEnvName + "):$(" + ProxyPortEnvName + ")"))
+				warnings, err := NewProxyEnvInjector().Admit(pod, config, NewPodAdmission(config, pod, nsInfo))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(warnings).To(ConsistOf(ContainSubstring("status.hostIP of the cluster family IPv4 instead of proxyIPFamily IPv6")))
+
+				By("bracketing the host IP of IPv6 primary clusters")
+				nsInfo.HostIPFamily = NodeHostIPFamily(node("fd00::1", "10.0.0.1").Node)
+				Expect(proxyURL(pod, nsInfo)).To(Equal("http://[$(" + HostIPEnvName + ")]:$(" + ProxyPortEnvName + ")"))
+				Expect(NewProxyEnvInjector().Admit(pod, config, NewPodAdmission(config, pod, nsInfo))).To(BeEmpty())
+			})
+
+			It("should keep the maven settings of bound pods consistent", func() {
+				config.EcosystemMirrors = &EcosystemMirrors{Maven: "/maven2"}
+				nsInfo := node("fd00::1")
+				nsInfo.HostIPFamily = NodeHostIPFamily(nsInfo.Node)
+				bound := MavenSettingsConfigMap(config, NewPodAdmission(config, boundPod(), nsInfo))
+				Expect(MavenSettingsConfigMap(config, NewPodAdmission(config, pod, nsInfo))).To(Equal(bound))
+				Expect(bound.Data[MavenSettingsFileName]).To(ContainSubstring("[${env." + HostIPEnvName + "}]"))
+			})
+		})
+
+		It("should use the node IP for the mirrors", func() {
+			config.ProxyIPFamily = corev1.IPv6Protocol
+			config.EcosystemMirrors = &EcosystemMirrors{Pip: "/pypi/simple", Maven: "/maven2"}
+			pod := boundPod()
+			pod.Annotations = map[string]string{MirrorsAnnotation: "pip"}
+			NewMirrorsInjector().Inject(pod, config, nil)
+			Expect(pod.Spec.Containers[0].Env).To(ContainElements(
+				HaveField("Name", HostIPEnvName),
+				corev1.EnvVar{Name: "PIP_INDEX_URL", Value: "http://[$(" + HostIPEnvName + ")]:4001/pypi/simple"},
+			))
+			Expect(MavenSettingsConfigMap(config, nil).Data[MavenSettingsFileName]).To(
+				ContainSubstring("<url>http://[${env." + HostIPEnvName + "}]:4001/maven2</url>"))
+		})
+
+		It("should validate the proxy host", func() {
+			Expect(validateProxyHost(config)).To(Succeed())
+			config.ProxyIPFamily = "IPv5"
+			Expect(validateProxyHost(config)).To(MatchError(ContainSubstring("invalid proxy ip family")))
+			config.ProxyHost = "ExternalIP"
+			Expect(validateProxyHost(config)).To(MatchError(ContainSubstring("invalid proxy host")))
+		})
+	})
 })
@@ -0,0 +1,175 @@
+package injector
+
+import (
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Addresses of the node dfdaemon in ProxyModeNode
+const (
+	ProxyHostNodeName string = "NodeName" // the node name, for clusters resolving node names
+	ProxyHostHostIP   string = "HostIP"   // the node IP, from status.hostIP or the addresses of the node
+)
+
+func validateProxyHost(c *InjectConf) error {
+	switch c.ProxyHost {
+	case "", ProxyHostNodeName, ProxyHostHostIP:
+	default:
+		return fmt.Errorf("invalid proxy host %q", c.ProxyHost)
+	}
+	switch c.ProxyIPFamily {
+	case "", corev1.IPv4Protocol, corev1.IPv6Protocol:
+	default:
+		return fmt.Errorf("invalid proxy ip family %q", c.ProxyIPFamily)
+	}
+	return nil
+}
+
+// kubeletEnvRef references an env var, the kubelet expands it in env values, command and args.
+func kubeletEnvRef(name string) string {
+	return "$(" + name + ")"
+}
+
+// dfdaemonHost returns the host pods reach the dfdaemon at, usable in URLs: the host set by the admission,
+// which may be nil, the Service in ProxyModeService, the node IP, or the node name. The node is referenced
+// through its env var with envRef, which is expanded in the pod. IPv6 addresses are enclosed in brackets.
+func dfdaemonHost(config *InjectConf, admission *PodAdmission, envRef func(name string) string) string {
+	var proxyHost string
+	var family corev1.IPFamily
+	if admission != nil {
+		proxyHost, family = admission.proxyHost, admission.hostIPFamily
+	}
+	switch {
+	case proxyHost != "":
+		return proxyHost
+	case config.ProxyMode == ProxyModeService && config.ProxyService != nil:
+		return config.ProxyService.host()
+	case config.ProxyHost != ProxyHostHostIP:
+		return envRef(NodeNameEnvName)
+	case family == corev1.IPv6Protocol || family == "" && config.ProxyIPFamily == corev1.IPv6Protocol:
+		return "[" + envRef(HostIPEnvName) + "]"
+	default:
+		return envRef(HostIPEnvName)
+	}
+}
+
+// DfdaemonHost returns the host the pod reaches the dfdaemon at, as injected into its env vars.
+func DfdaemonHost(config *InjectConf, admission *PodAdmission) string {
+	return dfdaemonHost(config, admission, kubeletEnvRef)
+}
+
+// urlHost returns the IP as the host of a URL.
+func urlHost(ip netip.Addr) string {
+	if ip.Is6() {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+// dfdaemonHostEnvs returns the env vars dfdaemonHost references.
+func dfdaemonHostEnvs(config *InjectConf) []corev1.EnvVar {
+	envs := []corev1.EnvVar{nodeNameEnv()}
+	if config.ProxyHost != ProxyHostHostIP || config.ProxyMode == ProxyModeService {
+		return envs
+	}
+	return append(envs,
+		corev1.EnvVar{
+			Name:      HostIPEnvName,
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}},
+		},
+		// all node IPs, comma separated, for clients choosing the family themselves
+		corev1.EnvVar{
+			Name:      HostIPsEnvName,
+			ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIPs"}},
+		},
+	)
+}
+
+// nodeNameEnv returns the env var with the name of the pod's node, the host of the node dfdaemon.
+func nodeNameEnv() corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: NodeNameEnvName,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: "spec.nodeName",
+			},
+		},
+	}
+}
+
+// NodeHostIPFamily returns the family of the IP the kubelet reports in status.hostIP of the pods of the node:
+// its first InternalIP, or its first ExternalIP without one. It returns an empty family for nodes without either.
+func NodeHostIPFamily(node *corev1.Node) corev1.IPFamily {
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeExternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type != addressType {
+				continue
+			}
+			if ip, err := netip.ParseAddr(address.Address); err == nil {
+				if ip.Unmap().Is6() {
+					return corev1.IPv6Protocol
+				}
+				return corev1.IPv4Protocol
+			}
+		}
+	}
+	return ""
+}
+
+// hostIPFamilyForPod returns the family of status.hostIP, which the URLs of pods not bound to a node at creation
+// and the Maven settings reference, when it differs from ProxyIPFamily. Downward API can't select the family of
+// status.hostIP, so the brackets of IPv6 addresses follow the cluster rather than ProxyIPFamily.
+func hostIPFamilyForPod(config *InjectConf, nsInfo *NamespaceInfo) corev1.IPFamily {
+	if config.ProxyHost != ProxyHostHostIP || config.ProxyMode == ProxyModeService || nsInfo == nil {
+		return ""
+	}
+	family := config.ProxyIPFamily
+	if family == "" {
+		family = corev1.IPv4Protocol
+	}
+	if nsInfo.HostIPFamily == "" || nsInfo.HostIPFamily == family {
+		return ""
+	}
+	return nsInfo.HostIPFamily
+}
+
+// hostIPFamilyWarning returns a warning when the pod reaches the dfdaemon at status.hostIP of another family
+// than the configured ProxyIPFamily.
+func hostIPFamilyWarning(config *InjectConf, admission *PodAdmission) string {
+	if admission == nil || admission.proxyHost != "" || admission.hostIPFamily == "" || config.ProxyIPFamily == "" {
+		return ""
+	}
+	return fmt.Sprintf("the pod is not bound to a node, the proxy is reached at status.hostIP of the cluster family %s "+
+		"instead of proxyIPFamily %s, %s lists the node IPs of both families", admission.hostIPFamily, config.ProxyIPFamily, HostIPsEnvName)
+}
+
+// proxyHostIPForPod returns the node IP of the proxy for pods bound to a node at creation, whose IPs are
+// known at admission time. It prefers the InternalIP of ProxyIPFamily, IPv4 when unset, and falls back to
+// the first InternalIP of the node. It returns an invalid address for pods without a known node.
+func proxyHostIPForPod(config *InjectConf, pod *corev1.Pod, nsInfo *NamespaceInfo) netip.Addr {
+	if config.ProxyHost != ProxyHostHostIP || config.ProxyMode == ProxyModeService ||
+		nsInfo == nil || nsInfo.Node == nil || pod.Spec.NodeName == "" || nsInfo.Node.Name != pod.Spec.NodeName {
+		return netip.Addr{}
+	}
+	wantIPv6 := config.ProxyIPFamily == corev1.IPv6Protocol
+	var fallback netip.Addr
+	for _, address := range nsInfo.Node.Status.Addresses {
+		if address.Type != corev1.NodeInternalIP {
+			continue
+		}
+		ip, err := netip.ParseAddr(address.Address)
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.Is6() == wantIPv6 {
+			return ip
+		}
+		if !fallback.IsValid() {
+			fallback = ip
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,52 @@
+package injector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Modes of reaching the dfdaemon
+const (
+	ProxyModeNode    string = "Node"    // the dfdaemon of the node, at the address of ProxyHost
+	ProxyModeService string = "Service" // a Service routing to the dfdaemon of the node, for clusters blocking node addresses
+)
+
+// ProxyService is the Service in front of the dfdaemon DaemonSet used by ProxyModeService. It must set
+// internalTrafficPolicy: Local, so pods reach the dfdaemon of their node, and expose the dfdaemon ports
+// under the same numbers.
+type ProxyService struct {
+	Namespace string `yaml:"namespace" json:"namespace"`
+	Name      string `yaml:"name" json:"name"`
+	// Port of the dfdaemon gRPC server, injected as DfdaemonEndpointEnvName into pods without the unix
+	// socket, none when unset
+	GRPCPort int `yaml:"grpcPort,omitempty" json:"grpcPort,omitempty"`
+}
+
+func validateProxyMode(c *InjectConf) error {
+	switch c.ProxyMode {
+	case "", ProxyModeNode:
+	case ProxyModeService:
+		if c.ProxyService == nil || c.ProxyService.Namespace == "" || c.ProxyService.Name == "" {
+			return errors.New("proxy mode Service requires the proxyService namespace and name")
+		}
+	default:
+		return fmt.Errorf("invalid proxy mode %q", c.ProxyMode)
+	}
+	return nil
+}
+
+// host returns the DNS name of the Service, resolved through the cluster domain search path.
+func (s *ProxyService) host() string {
+	return s.Name + "." + s.Namespace + ".svc"
+}
+
+// dfdaemonEndpoint returns the gRPC endpoint of the dfdaemon for pods without the unix socket, or an
+// empty string if the dfdaemon is only reachable through the socket.
+func dfdaemonEndpoint(config *InjectConf) string {
+	if !config.DisableUnixSocket || config.ProxyMode != ProxyModeService ||
+		config.ProxyService == nil || config.ProxyService.GRPCPort == 0 {
+		return ""
+	}
+	return "http://" + config.ProxyService.host() + ":" + strconv.Itoa(config.ProxyService.GRPCPort)
+}
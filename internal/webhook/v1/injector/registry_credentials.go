@@ -0,0 +1,91 @@
+package injector
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// registryCredentials are the credentials of a registry in an image pull secret.
+type registryCredentials struct {
+	username string
+	password string
+}
+
+// dockerConfigEntry is the entry of a registry in a .dockerconfigjson or .dockercfg pull secret.
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"` // base64 of username:password
+}
+
+func (e *dockerConfigEntry) credentials() *registryCredentials {
+	if e.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return nil
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil
+		}
+		return &registryCredentials{username: username, password: password}
+	}
+	if e.Username == "" {
+		return nil
+	}
+	return &registryCredentials{username: e.Username, password: e.Password}
+}
+
+// pullSecretCredentials returns the credentials of the registry in the first pull secret holding them,
+// nil if there are none.
+func pullSecretCredentials(secrets []corev1.Secret, registry string) *registryCredentials {
+	for i := range secrets {
+		for key, entry := range dockerConfigEntries(&secrets[i]) {
+			if dockerConfigRegistry(key) != registry {
+				continue
+			}
+			if credentials := entry.credentials(); credentials != nil {
+				return credentials
+			}
+		}
+	}
+	return nil
+}
+
+// dockerConfigEntries returns the registry entries of the pull secret, by registry key.
+func dockerConfigEntries(secret *corev1.Secret) map[string]dockerConfigEntry {
+	if data, ok := secret.Data[corev1.DockerConfigJsonKey]; ok {
+		var config struct {
+			Auths map[string]dockerConfigEntry `json:"auths"`
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			podlog.Error(err, "ignore invalid pull secret", "namespace", secret.Namespace, "name", secret.Name)
+			return nil
+		}
+		return config.Auths
+	}
+	if data, ok := secret.Data[corev1.DockerConfigKey]; ok {
+		var entries map[string]dockerConfigEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			podlog.Error(err, "ignore invalid pull secret", "namespace", secret.Namespace, "name", secret.Name)
+			return nil
+		}
+		return entries
+	}
+	return nil
+}
+
+// dockerConfigRegistry returns the registry of a docker config key, which may be a URL like
+// https://index.docker.io/v1/, as normalized in image references.
+func dockerConfigRegistry(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	switch key {
+	case legacyImageRegistry, dockerHubRegistryHost:
+		return defaultImageRegistry
+	}
+	return key
+}
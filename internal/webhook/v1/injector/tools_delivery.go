@@ -0,0 +1,110 @@
+package injector
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	// Ways of delivering the cli tools into the pods
+	CliToolsDeliveryModeInitContainer string = "InitContainer" // Copy the tools into an emptyDir with an initContainer
+	CliToolsDeliveryModeImageVolume   string = "ImageVolume"   // Mount the cli tools image as a read-only image volume
+	CliToolsDeliveryModeHostPath      string = "HostPath"      // Mount the tools cached on the node, see CliToolsHostPath
+	CliToolsDeliveryModeAuto          string = "Auto"          // ImageVolume if the server version supports it, else InitContainer
+
+	// Ways of installing the cli tools with the initContainer
+	CliToolsInstallModeCopy       string = "Copy"       // Copy CliToolsDirPath with cp, the image must provide cp (and sh to select tools)
+	CliToolsInstallModeEntrypoint string = "Entrypoint" // Run the image entrypoint with "install --to <dir> [tool...]"
+
+	// DefaultCliToolsHostPath is the node directory caching the cli tools, one subdirectory per cli tools version.
+	DefaultCliToolsHostPath string = "/var/lib/dragonfly/cli-tools"
+
+	// DefaultImageVolumeMinVersion is the first Kubernetes version enabling image volumes by default.
+	DefaultImageVolumeMinVersion string = "v1.35.0"
+)
+
+func validateCliToolsDelivery(c *InjectConf) error {
+	switch c.CliToolsDeliveryMode {
+	case "", CliToolsDeliveryModeInitContainer, CliToolsDeliveryModeImageVolume,
+		CliToolsDeliveryModeHostPath, CliToolsDeliveryModeAuto:
+	default:
+		return fmt.Errorf("invalid cli tools delivery mode %q", c.CliToolsDeliveryMode)
+	}
+	switch c.CliToolsInstallMode {
+	case "", CliToolsInstallModeCopy, CliToolsInstallModeEntrypoint:
+	default:
+		return fmt.Errorf("invalid cli tools install mode %q", c.CliToolsInstallMode)
+	}
+	if c.CliToolsHostPath != "" && !path.IsAbs(c.CliToolsHostPath) {
+		return fmt.Errorf("cli tools host path %q is not absolute", c.CliToolsHostPath)
+	}
+	if c.ImageVolumeMinVersion != "" {
+		if _, err := version.ParseGeneric(c.ImageVolumeMinVersion); err != nil {
+			return fmt.Errorf("invalid image volume min version %q: %w", c.ImageVolumeMinVersion, err)
+		}
+	}
+	return nil
+}
+
+// cliToolsHostPath returns the configured node directory caching the cli tools, or the default.
+func (c *InjectConf) cliToolsHostPath() string {
+	if c.CliToolsHostPath != "" {
+		return c.CliToolsHostPath
+	}
+	return DefaultCliToolsHostPath
+}
+
+// cliToolsCacheKey returns the subdirectory of the node cache holding the tools of the image: the digest
+// with ":" replaced by "-" for pinned images, the tag otherwise, and "latest" for images without either.
+func cliToolsCacheKey(image string) string {
+	ref, err := parseImageReference(image)
+	switch {
+	case err != nil:
+		return "latest"
+	case ref.Digest != "":
+		return strings.ReplaceAll(ref.Digest, ":", "-")
+	case ref.Tag != "":
+		return ref.Tag
+	default:
+		return "latest"
+	}
+}
+
+// imageVolumeMinVersion returns the configured first server version supporting image volumes, or the default.
+func (c *InjectConf) imageVolumeMinVersion() *version.Version {
+	if c.ImageVolumeMinVersion != "" {
+		if v, err := version.ParseGeneric(c.ImageVolumeMinVersion); err == nil {
+			return v
+		}
+	}
+	return version.MustParseGeneric(DefaultImageVolumeMinVersion)
+}
+
+// resolveCliToolsDeliveryMode returns the delivery mode for the cluster, resolving
+// CliToolsDeliveryModeAuto with the server version. Without a known server version,
+// Auto falls back to the initContainer.
+func resolveCliToolsDeliveryMode(config *InjectConf, nsInfo *NamespaceInfo) string {
+	switch config.CliToolsDeliveryMode {
+	case CliToolsDeliveryModeImageVolume:
+		return CliToolsDeliveryModeImageVolume
+	case CliToolsDeliveryModeAuto:
+	default:
+		return CliToolsDeliveryModeInitContainer
+	}
+
+	if nsInfo == nil || nsInfo.ServerVersion == nil {
+		return CliToolsDeliveryModeInitContainer
+	}
+	serverVersion, err := version.ParseGeneric(nsInfo.ServerVersion.GitVersion)
+	if err != nil {
+		podlog.Error(err, "ignore invalid server version", "version", nsInfo.ServerVersion.GitVersion)
+		return CliToolsDeliveryModeInitContainer
+	}
+	if serverVersion.AtLeast(config.imageVolumeMinVersion()) {
+		return CliToolsDeliveryModeImageVolume
+	}
+	return CliToolsDeliveryModeInitContainer
+}
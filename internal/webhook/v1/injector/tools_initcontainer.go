@@ -1,9 +1,14 @@
 package injector
 
 import (
-	"path/filepath"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
 )
 
 type ToolsInitcontainerInjector struct{}
@@ -12,67 +17,252 @@ func NewToolsInitcontainerInjector() *ToolsInitcontainerInjector {
 	return &ToolsInitcontainerInjector{}
 }
 
-func (tii *ToolsInitcontainerInjector) Inject(pod *corev1.Pod, config *InjectConf) {
+func (tii *ToolsInitcontainerInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
 	podlog.Info("ToolsInitcontainerInjector Inject")
-
-	cliToolsVolumeMountPath := filepath.Clean(config.CliToolsDirPath) + "-mount"
-	initContainerCmd := []string{
-		"cp",
-		"-rf",
-		config.CliToolsDirPath + "/.",
-		cliToolsVolumeMountPath + "/",
+	if config.DisableCliTools {
+		podlog.Info("ToolsInitcontainerInjector disabled, skip inject")
+		return
 	}
-	// get initContainerImage
-	annotations := pod.Annotations
-	initContainerImage := config.CliToolsImage
-	if annotations != nil {
-		if image, ok := annotations[CliToolsImageAnnotation]; ok {
-			initContainerImage = image
+
+	cliToolsVolumeMountPath := config.cliToolsVolumeMountPath()
+	// get cliToolsImage, disallowed annotation images are reported by Admit
+	cliToolsImage := CliToolsImageForPod(config, admission, pod)
+
+	// cliToolsPath is the directory of the tools in the app containers
+	cliToolsPath := cliToolsVolumeMountPath
+	var toolsVolumeSource corev1.VolumeSource
+	switch config.CliToolsDeliveryMode {
+	case CliToolsDeliveryModeImageVolume:
+		// the whole image is mounted, the tools keep their directory in the image
+		cliToolsPath = path.Join(cliToolsVolumeMountPath, config.CliToolsDirPath)
+		toolsVolumeSource.Image = &corev1.ImageVolumeSource{
+			Reference:  cliToolsImage,
+			PullPolicy: config.cliToolsImagePullPolicy(),
 		}
-	}
-	// add initContainer
-	if !tii.CheckInitContainerIsExist(pod) {
+	case CliToolsDeliveryModeHostPath:
+		// the node agent populates the directory, the pod waits for it to exist
+		toolsVolumeSource.HostPath = &corev1.HostPathVolumeSource{
+			Path: path.Join(config.cliToolsHostPath(), cliToolsCacheKey(cliToolsImage)),
+			Type: ptr.To(corev1.HostPathDirectory),
+		}
+	default:
+		toolsVolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: config.CliToolsVolumeMedium}
+		if config.CliToolsVolumeSizeLimit != nil {
+			toolsVolumeSource.EmptyDir.SizeLimit = ptr.To(config.CliToolsVolumeSizeLimit.DeepCopy())
+		}
+		// add initContainer
 		toolContainer := &corev1.Container{
 			Name:            CliToolsInitContainerName,
-			Image:           initContainerImage,
-			ImagePullPolicy: corev1.PullIfNotPresent,
+			Image:           cliToolsImage,
+			ImagePullPolicy: config.cliToolsImagePullPolicy(),
+			Resources:       config.cliToolsInitContainerResources(),
+			SecurityContext: config.cliToolsSecurityContext().DeepCopy(),
 			VolumeMounts: []corev1.VolumeMount{
 				{
 					Name:      CliToolsVolumeName,
 					MountPath: cliToolsVolumeMountPath,
 				},
 			},
-			Command: initContainerCmd,
 		}
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, *toolContainer)
-	}
-
-	if !tii.CheckVolumeIsExist(pod) {
-		toolsVolume := &corev1.Volume{
-			Name: CliToolsVolumeName,
-			VolumeSource: corev1.VolumeSource{
-				EmptyDir: &corev1.EmptyDirVolumeSource{},
-			},
+		if config.CliToolsInstallMode == CliToolsInstallModeEntrypoint {
+			toolContainer.Args = cliToolsInstallArgs(cliToolsVolumeMountPath, config.CliTools)
+		} else {
+			toolContainer.Command = cliToolsCopyCommand(config.CliToolsDirPath, cliToolsVolumeMountPath, config.CliTools)
 		}
-		pod.Spec.Volumes = append(pod.Spec.Volumes, *toolsVolume)
+		addInitContainer(config, admission, pod, *toolContainer)
 	}
 
+	tii.injectImagePullSecrets(pod, config)
+
+	addVolume(config, admission, pod, corev1.Volume{
+		Name:         CliToolsVolumeName,
+		VolumeSource: toolsVolumeSource,
+	})
+
 	// add volumeMount and env
 	for i := range pod.Spec.Containers {
-		if !tii.CheckVolumeMountIsExist(&pod.Spec.Containers[i]) {
-			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
-				Name:      CliToolsVolumeName,
-				MountPath: cliToolsVolumeMountPath,
-			})
+		addVolumeMount(config, admission, &pod.Spec.Containers[i], corev1.VolumeMount{
+			Name:      CliToolsVolumeName,
+			MountPath: cliToolsVolumeMountPath,
+			ReadOnly:  cliToolsVolumeReadOnly(config),
+		})
+		injectContainer(config, admission, &pod.Spec.Containers[i], []corev1.EnvVar{{Name: CliToolsPathEnvName, Value: cliToolsPath}})
+		if config.CliToolsAddToPath {
+			tii.injectPath(&pod.Spec.Containers[i], cliToolsPath, config.CliToolsBasePath)
+		}
+	}
+
+}
+
+// Admit checks the cli tools image requested by the pod annotation against the image policy,
+// and the cli tools requested by the pod annotation.
+func (tii *ToolsInitcontainerInjector) Admit(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) ([]string, error) {
+	_, warnings, err := cliToolsImageForPod(config, pod)
+	if err != nil {
+		return nil, err
+	}
+	tools, warning := cliToolsForPod(config, pod)
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if unlisted := unlistedCliTools(config, tools); len(unlisted) > 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"cli tools %s are not in the configured cli tools, tool names are only checked against the cli tools image"+
+				" when the pod starts, the initContainer fails on unknown tools", strings.Join(unlisted, ", ")))
+	}
+	if len(tools) > 0 && cliToolsVolumeReadOnly(config) {
+		warnings = append(warnings, fmt.Sprintf(
+			"cli tools selection is ignored, the %s delivery mode mounts all cli tools", config.CliToolsDeliveryMode))
+	}
+	if config.CliToolsDeliveryMode == CliToolsDeliveryModeHostPath {
+		if key := cliToolsCacheKey(CliToolsImageForPod(config, admission, pod)); key == "latest" {
+			warnings = append(warnings, fmt.Sprintf(
+				"the node cache of cli tools version %q is never refreshed, set a version tag in the cli tools image", key))
+		}
+	}
+	if config.CliToolsAddToPath && config.CliToolsBasePath == "" && !config.DisableCliTools {
+		if names := containersWithoutPath(pod); len(names) > 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"cli tools are not added to the PATH of containers without a PATH env: %s, use $%s or set cliToolsBasePath",
+				strings.Join(names, ", "), CliToolsPathEnvName))
+		}
+	}
+	return warnings, nil
+}
+
+// containersWithoutPath returns the names of the containers that don't set PATH in their env.
+func containersWithoutPath(pod *corev1.Pod) []string {
+	var names []string
+	for _, c := range pod.Spec.Containers {
+		if !slices.ContainsFunc(c.Env, func(e corev1.EnvVar) bool { return e.Name == PathEnvName }) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// cliToolsVolumeReadOnly reports whether the delivery mode mounts the tools without copying them.
+func cliToolsVolumeReadOnly(config *InjectConf) bool {
+	return config.CliToolsDeliveryMode == CliToolsDeliveryModeImageVolume ||
+		config.CliToolsDeliveryMode == CliToolsDeliveryModeHostPath
+}
+
+// cliToolsCopyScript copies the tools given as arguments after the source and target directory, and
+// fails on tools missing from the manifest of the cli tools image.
+const cliToolsCopyScript = `src="$1"; dst="$2"; shift 2
+for tool in "$@"; do
+  if ! grep -qxF "$tool" "$src/` + CliToolsManifestFileName + `"; then
+    echo "unknown cli tool $tool, see $src/` + CliToolsManifestFileName + `" >&2; exit 1
+  fi
+  cp -f "$src/$tool" "$dst/"
+done`
+
+// cliToolsCopyCommand returns the initContainer command copying the tools, or the whole directory without tools.
+func cliToolsCopyCommand(dirPath string, mountPath string, tools []string) []string {
+	if len(tools) == 0 {
+		return []string{"cp", "-rf", dirPath + "/.", mountPath + "/"}
+	}
+	// The tool names are passed as arguments, so they are never interpreted by the shell.
+	return append([]string{"sh", "-c", cliToolsCopyScript, CliToolsInitContainerName, dirPath, mountPath}, tools...)
+}
+
+// cliToolsInstallArgs returns the arguments of the self-installing entrypoint of the cli tools image,
+// which installs the tools, or all tools without tools, into the mount path.
+func cliToolsInstallArgs(mountPath string, tools []string) []string {
+	return append([]string{"install", "--to", mountPath}, tools...)
+}
+
+var cliToolNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validCliToolName reports whether the name is a plain file name, which can't escape the cli tools directory.
+func validCliToolName(name string) bool {
+	return cliToolNameRegexp.MatchString(name)
+}
+
+// cliToolsForPod returns the cli tools requested by the CliToolsAnnotation, or the configured ones if the pod
+// doesn't have the annotation. An annotation with invalid tool names is ignored, the warning explains why.
+func cliToolsForPod(config *InjectConf, pod *corev1.Pod) ([]string, string) {
+	value, ok := pod.GetAnnotations()[CliToolsAnnotation]
+	if !ok {
+		return config.CliTools, ""
+	}
+	var tools []string
+	for _, tool := range strings.Split(value, ",") {
+		tool = strings.TrimSpace(tool)
+		if tool == "" || slices.Contains(tools, tool) {
+			continue
+		}
+		if !validCliToolName(tool) {
+			return config.CliTools, fmt.Sprintf("annotation %s is ignored: invalid cli tool name %q", CliToolsAnnotation, tool)
+		}
+		tools = append(tools, tool)
+	}
+	return tools, ""
+}
+
+// unlistedCliTools returns the tools requested by the pod annotation that are missing from the configured
+// tools, all of them if none are configured. The names can't be checked against the image at admission time.
+func unlistedCliTools(config *InjectConf, tools []string) []string {
+	if slices.Equal(tools, config.CliTools) {
+		return nil
+	}
+	var unlisted []string
+	for _, tool := range tools {
+		if !slices.Contains(config.CliTools, tool) {
+			unlisted = append(unlisted, tool)
 		}
-		if !tii.CheckEnvIsExist(&pod.Spec.Containers[i]) {
-			pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, corev1.EnvVar{
-				Name:  CliToolsPathEnvName,
-				Value: cliToolsVolumeMountPath,
-			})
+	}
+	return unlisted
+}
+
+// cliToolsVolumeMountPath returns the path the cli tools volume is mounted at in all containers.
+func cliToolsVolumeMountPath(config *InjectConf) string {
+	return path.Clean(config.CliToolsDirPath) + "-mount"
+}
+
+// injectPath prepends the cli tools directory to the PATH env of the container. The PATH of the image can't
+// be read at admission time, containers without a PATH env get the base path, or keep the PATH of their image
+// if the base path is empty.
+func (tii *ToolsInitcontainerInjector) injectPath(c *corev1.Container, dir string, basePath string) {
+	for i := range c.Env {
+		env := &c.Env[i]
+		if env.Name != PathEnvName {
+			continue
 		}
+		if env.ValueFrom != nil {
+			podlog.Info("skip adding cli tools to PATH, the container PATH is not a literal value", "container", c.Name)
+			return
+		}
+		if slices.Contains(strings.Split(env.Value, containerPathListSeparator), dir) {
+			return
+		}
+		if env.Value == "" {
+			env.Value = dir
+		} else {
+			env.Value = dir + containerPathListSeparator + env.Value
+		}
+		return
+	}
+	if basePath == "" {
+		podlog.Info("skip adding cli tools to PATH, the container doesn't set PATH", "container", c.Name)
+		return
 	}
+	c.Env = append(c.Env, corev1.EnvVar{
+		Name:  PathEnvName,
+		Value: dir + containerPathListSeparator + basePath,
+	})
+}
 
+// injectImagePullSecrets adds the cli tools pull secrets the pod doesn't reference yet.
+func (tii *ToolsInitcontainerInjector) injectImagePullSecrets(pod *corev1.Pod, config *InjectConf) {
+	for _, name := range config.cliToolsImagePullSecrets() {
+		exist := slices.ContainsFunc(pod.Spec.ImagePullSecrets, func(ref corev1.LocalObjectReference) bool {
+			return ref.Name == name
+		})
+		if !exist {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+	}
 }
 
 // check initContainer is exist
@@ -2,12 +2,18 @@ package injector
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/utils/ptr"
 )
 
 var _ = Describe("ToolsInitcontainerInjector", func() {
@@ -99,7 +105,7 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 				expectedPod.Spec.Containers[0].Env = []corev1.EnvVar{makeExpectedEnvVar(defaultMountPath)}
 
 				By("performing injection")
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
@@ -120,7 +126,7 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 				expectedPod.Spec.Containers[0].Env = []corev1.EnvVar{makeExpectedEnvVar(defaultMountPath)}
 
 				By("performing injection")
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
@@ -143,7 +149,7 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 				expectedPod.Spec.Containers[1].Env = []corev1.EnvVar{makeExpectedEnvVar(defaultMountPath)}
 
 				By("performing injection")
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
@@ -170,12 +176,97 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 
 				By("performing injection")
 				config := &InjectConf{CliToolsDirPath: defaultCliToolsDir, CliToolsImage: defaultCliToolsImage}
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
 			})
 
+			It("should set the configured resources on the init container", func() {
+				By("creating a config with init container resources")
+				pod := makePod("test-pod-resources", 1, nil)
+				resources := corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+					Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+				}
+				config := &InjectConf{
+					CliToolsDirPath:   defaultCliToolsDir,
+					CliToolsImage:     defaultCliToolsImage,
+					CliToolsResources: resources,
+				}
+
+				By("performing injection")
+				injector.Inject(pod, config, nil)
+
+				By("verifying the init container resources")
+				Expect(pod.Spec.InitContainers).To(HaveLen(1))
+				Expect(pod.Spec.InitContainers[0].Resources).To(Equal(resources))
+			})
+
+			It("should use the configured security context", func() {
+				By("creating a config with an empty security context")
+				pod := makePod("test-pod-security-context", 1, nil)
+				config := &InjectConf{
+					CliToolsDirPath:         defaultCliToolsDir,
+					CliToolsImage:           defaultCliToolsImage,
+					CliToolsSecurityContext: &corev1.SecurityContext{},
+				}
+
+				By("performing injection")
+				injector.Inject(pod, config, nil)
+
+				By("verifying the init container security context")
+				Expect(pod.Spec.InitContainers).To(HaveLen(1))
+				Expect(pod.Spec.InitContainers[0].SecurityContext).To(Equal(&corev1.SecurityContext{}))
+			})
+
+			It("should use the restricted security context if enabled", func() {
+				pod := makePod("test-pod-restricted-security-context", 1, nil)
+				config := &InjectConf{
+					CliToolsDirPath:                   defaultCliToolsDir,
+					CliToolsImage:                     defaultCliToolsImage,
+					CliToolsRestrictedSecurityContext: true,
+				}
+				injector.Inject(pod, config, nil)
+				Expect(pod.Spec.InitContainers[0].SecurityContext).To(Equal(NewRestrictedCliToolsSecurityContext()))
+			})
+
+			It("should use the configured image pull policy", func() {
+				pod := makePod("test-pod-pull-policy", 1, nil)
+				config := &InjectConf{
+					CliToolsDirPath:         defaultCliToolsDir,
+					CliToolsImage:           defaultCliToolsImage,
+					CliToolsImagePullPolicy: corev1.PullAlways,
+				}
+
+				injector.Inject(pod, config, nil)
+
+				Expect(pod.Spec.InitContainers).To(HaveLen(1))
+				Expect(pod.Spec.InitContainers[0].ImagePullPolicy).To(Equal(corev1.PullAlways))
+			})
+
+			It("should merge the image pull secrets without duplicates", func() {
+				By("creating a pod that already references one of the secrets")
+				pod := makePod("test-pod-pull-secrets", 1, nil)
+				pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "app-registry"}, {Name: "mirror"}}
+				config := &InjectConf{
+					CliToolsDirPath:               defaultCliToolsDir,
+					CliToolsImage:                 defaultCliToolsImage,
+					CliToolsImagePullSecrets:      []string{"mirror", "mirror-backup"},
+					CliToolsImagePullSecretSource: &corev1.SecretReference{Namespace: "dragonfly-system", Name: "copied"},
+				}
+
+				By("performing injection twice")
+				injector.Inject(pod, config, nil)
+				injector.Inject(pod, config, nil)
+
+				By("verifying the merged image pull secrets")
+				Expect(pod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{
+					{Name: "app-registry"}, {Name: "mirror"}, {Name: "mirror-backup"}, {Name: "copied"},
+				}))
+				Expect(config.CliToolsImagePullSecrets).To(Equal([]string{"mirror", "mirror-backup"}))
+			})
+
 			It("should handle pods with no containers gracefully", func() {
 				By("creating a pod with no containers")
 				pod := makePod("test-pod-5", 0, nil)
@@ -189,7 +280,7 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 				expectedPod.Spec.Volumes = []corev1.Volume{makeExpectedVolume()}
 
 				By("performing injection")
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
@@ -214,7 +305,7 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 
 				By("performing injection")
 				config := &InjectConf{CliToolsDirPath: defaultCliToolsDir, CliToolsImage: defaultCliToolsImage}
-				injector.Inject(pod, config)
+				injector.Inject(pod, config, nil)
 
 				By("verifying the result")
 				Expect(pod).To(Equal(expectedPod))
@@ -222,6 +313,679 @@ var _ = Describe("ToolsInitcontainerInjector", func() {
 		})
 	})
 
+	Describe("Image policy", func() {
+		const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath: defaultCliToolsDir,
+				CliToolsImage:   defaultCliToolsImage,
+				CliToolsImagePolicy: &CliToolsImagePolicy{
+					AllowedRegistries:   []string{"harbor.internal"},
+					AllowedRepositories: []string{"dragonflyoss/*"},
+				},
+			}
+		})
+
+		DescribeTable("should decide whether the annotated image is allowed",
+			func(image string, allowed bool) {
+				By("admitting a pod with the image annotation")
+				pod := makePod("test-pod-policy", 1, map[string]string{CliToolsImageAnnotation: image})
+				warnings, err := injector.Admit(pod, config, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				By("performing injection")
+				injector.Inject(pod, config, nil)
+				Expect(pod.Spec.InitContainers).To(HaveLen(1))
+
+				By("verifying the decision")
+				if allowed {
+					Expect(warnings).To(BeEmpty())
+					Expect(pod.Spec.InitContainers[0].Image).To(Equal(image))
+				} else {
+					Expect(warnings).To(ConsistOf(ContainSubstring(CliToolsImageAnnotation)))
+					Expect(pod.Spec.InitContainers[0].Image).To(Equal(defaultCliToolsImage))
+				}
+			},
+			Entry("allowed registry", "harbor.internal/any/tools:v1", true),
+			Entry("allowed repository in familiar form", "dragonflyoss/cli-tools:v1", true),
+			Entry("allowed repository in normalized form", "docker.io/dragonflyoss/cli-tools:v1", true),
+			Entry("allowed repository in legacy form", "index.docker.io/dragonflyoss/cli-tools:v1", true),
+			Entry("nested repository", "dragonflyoss/nested/cli-tools:v1", false),
+			Entry("other repository", "attacker/cli-tools:v1", false),
+			Entry("other registry with allowed path", "evil.io/dragonflyoss/cli-tools:v1", false),
+			Entry("invalid reference", "Not An Image", false),
+		)
+
+		It("should allow any image without a policy", func() {
+			config.CliToolsImagePolicy = nil
+			pod := makePod("test-pod-no-policy", 1, map[string]string{CliToolsImageAnnotation: "attacker/tools"})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("should not check pods without the annotation", func() {
+			config.CliToolsImagePolicy.Action = ImagePolicyActionReject
+			warnings, err := injector.Admit(makePod("test-pod-default", 1, nil), config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("should require a digest when configured", func() {
+			config.CliToolsImagePolicy.RequireDigest = true
+
+			By("admitting a tagged image")
+			pod := makePod("test-pod-tag", 1, map[string]string{CliToolsImageAnnotation: "dragonflyoss/cli-tools:v1"})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("not pinned by digest")))
+
+			By("admitting a pinned image")
+			pod = makePod("test-pod-digest", 1, map[string]string{CliToolsImageAnnotation: "dragonflyoss/cli-tools@" + digest})
+			warnings, err = injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("should reject disallowed images when configured", func() {
+			config.CliToolsImagePolicy.Action = ImagePolicyActionReject
+			pod := makePod("test-pod-reject", 1, map[string]string{CliToolsImageAnnotation: "attacker/cli-tools:v1"})
+			_, err := injector.Admit(pod, config, nil)
+			Expect(err).To(MatchError(ContainSubstring("is not allowed")))
+		})
+
+		It("should use the precompiled policy", func() {
+			config.compile()
+			config.CliToolsImagePolicy = nil
+			pod := makePod("test-pod-compiled", 1, map[string]string{CliToolsImageAnnotation: "attacker/cli-tools:v1"})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+		})
+	})
+
+	Describe("Tool selection", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath: defaultCliToolsDir,
+				CliToolsImage:   defaultCliToolsImage,
+				CliTools:        []string{"dfget", "dfcache"},
+			}
+		})
+
+		It("should copy only the configured tools", func() {
+			pod := makePod("test-pod-tools", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Command).To(Equal([]string{
+				"sh", "-c", cliToolsCopyScript, CliToolsInitContainerName,
+				defaultCliToolsDir, defaultMountPath, "dfget", "dfcache",
+			}))
+		})
+
+		It("should apply the tools annotation through the effective config", func() {
+			pod := makePod("test-pod-tools-annotation", 1, map[string]string{CliToolsAnnotation: " dfget, dfstore,dfget,"})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(
+				ContainSubstring("cli tools dfstore are not in the configured cli tools, tool names are only checked"),
+			))
+
+			injector.Inject(pod, EffectiveConfig(config, pod, nil), nil)
+			Expect(pod.Spec.InitContainers[0].Command[5:]).To(Equal([]string{defaultMountPath, "dfget", "dfstore"}))
+		})
+
+		It("should ignore an annotation with invalid tool names", func() {
+			pod := makePod("test-pod-tools-invalid", 1, map[string]string{CliToolsAnnotation: "dfget,../../bin/sh"})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring(`invalid cli tool name "../../bin/sh"`)))
+
+			injector.Inject(pod, EffectiveConfig(config, pod, nil), nil)
+			Expect(pod.Spec.InitContainers[0].Command[6:]).To(Equal([]string{"dfget", "dfcache"}))
+		})
+
+		It("should reject invalid tool names in the config", func() {
+			config.CliTools = []string{"dfget", "-rf"}
+			Expect(config.validate()).To(MatchError(ContainSubstring(`invalid cli tool name "-rf"`)))
+		})
+
+		It("should check the tools against the manifest of the tools image", func() {
+			sh, err := exec.LookPath("sh")
+			if err != nil {
+				Skip("sh is not available")
+			}
+			src, dst := GinkgoT().TempDir(), GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(src, CliToolsManifestFileName), []byte("dfget\ndfcache\n"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(src, "dfget"), []byte("dfget"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(src, "dfcache"), []byte("dfcache"), 0755)).To(Succeed())
+
+			By("copying known tools")
+			cmd := cliToolsCopyCommand(src, dst, []string{"dfget"})
+			Expect(exec.Command(sh, cmd[1:]...).Run()).To(Succeed())
+			Expect(filepath.Join(dst, "dfget")).To(BeARegularFile())
+			Expect(filepath.Join(dst, "dfcache")).NotTo(BeAnExistingFile())
+
+			By("failing on unknown tools")
+			cmd = cliToolsCopyCommand(src, dst, []string{"dfstore"})
+			output, err := exec.Command(sh, cmd[1:]...).CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).To(ContainSubstring("unknown cli tool dfstore"))
+		})
+	})
+
+	Describe("Install mode", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath:     defaultCliToolsDir,
+				CliToolsImage:       defaultCliToolsImage,
+				CliToolsInstallMode: CliToolsInstallModeEntrypoint,
+			}
+		})
+
+		It("should run the image entrypoint to install all tools", func() {
+			pod := makePod("test-pod-entrypoint", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Command).To(BeNil())
+			Expect(pod.Spec.InitContainers[0].Args).To(Equal([]string{"install", "--to", defaultMountPath}))
+		})
+
+		It("should pass the selected tools to the entrypoint", func() {
+			config.CliTools = []string{"dfget", "dfcache"}
+			pod := makePod("test-pod-entrypoint-tools", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Command).To(BeNil())
+			Expect(pod.Spec.InitContainers[0].Args).To(Equal([]string{"install", "--to", defaultMountPath, "dfget", "dfcache"}))
+		})
+
+		It("should keep copying with cp in the compatibility mode", func() {
+			config.CliToolsInstallMode = CliToolsInstallModeCopy
+			pod := makePod("test-pod-copy", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Command).To(Equal([]string{"cp", "-rf", defaultCliToolsDir + "/.", defaultMountPath + "/"}))
+			Expect(pod.Spec.InitContainers[0].Args).To(BeNil())
+		})
+
+		It("should reject an invalid install mode", func() {
+			config.CliToolsInstallMode = "Download"
+			Expect(config.validate()).To(MatchError(ContainSubstring("invalid cli tools install mode")))
+		})
+	})
+
+	Describe("Volume medium and size limit", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath:         defaultCliToolsDir,
+				CliToolsImage:           defaultCliToolsImage,
+				CliToolsVolumeSizeLimit: ptr.To(resource.MustParse("256Mi")),
+			}
+		})
+
+		It("should limit the disk backed volume and request matching ephemeral storage", func() {
+			pod := makePod("test-pod-size-limit", 1, nil)
+			injector.Inject(pod, config, nil)
+
+			Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+				Name: CliToolsVolumeName,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+					SizeLimit: ptr.To(resource.MustParse("256Mi")),
+				}},
+			}))
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("256Mi"),
+			}))
+			Expect(config.CliToolsResources.Requests).To(BeNil())
+		})
+
+		It("should keep configured ephemeral storage and cap the request to the limit", func() {
+			config.CliToolsResources.Limits = corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("128Mi"),
+			}
+			pod := makePod("test-pod-size-limit-capped", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("128Mi"),
+			}))
+
+			config.CliToolsResources.Requests = corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("64Mi"),
+			}
+			pod = makePod("test-pod-size-limit-configured", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("64Mi"),
+			}))
+		})
+
+		It("should not request ephemeral storage for a memory backed volume", func() {
+			config.CliToolsVolumeMedium = corev1.StorageMediumMemory
+			pod := makePod("test-pod-memory-medium", 1, nil)
+			injector.Inject(pod, config, nil)
+
+			Expect(pod.Spec.Volumes[0].EmptyDir.Medium).To(Equal(corev1.StorageMediumMemory))
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(BeEmpty())
+		})
+
+		It("should apply the annotation overrides through the effective config", func() {
+			pod := makePod("test-pod-volume-annotations", 1, map[string]string{
+				CliToolsVolumeMediumAnnotation:    "Memory",
+				CliToolsVolumeSizeLimitAnnotation: "64Mi",
+			})
+			injector.Inject(pod, EffectiveConfig(config, pod, nil), nil)
+			Expect(pod.Spec.Volumes[0].EmptyDir).To(Equal(&corev1.EmptyDirVolumeSource{
+				Medium:    corev1.StorageMediumMemory,
+				SizeLimit: ptr.To(resource.MustParse("64Mi")),
+			}))
+			Expect(config.CliToolsVolumeSizeLimit.String()).To(Equal("256Mi"))
+		})
+
+		It("should ignore invalid annotations", func() {
+			pod := makePod("test-pod-volume-invalid", 1, map[string]string{
+				CliToolsVolumeMediumAnnotation:    "HugePages",
+				CliToolsVolumeSizeLimitAnnotation: "a lot",
+			})
+			Expect(EffectiveConfig(config, pod, nil)).To(BeIdenticalTo(config))
+		})
+
+		It("should reject an invalid medium in the config", func() {
+			config.CliToolsVolumeMedium = "Tape"
+			Expect(config.validate()).To(MatchError(ContainSubstring("invalid cli tools volume medium")))
+		})
+	})
+
+	Describe("Image volume delivery", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath:      defaultCliToolsDir,
+				CliToolsImage:        defaultCliToolsImage,
+				CliToolsDeliveryMode: CliToolsDeliveryModeImageVolume,
+				CliToolsAddToPath:    true,
+				CliToolsBasePath:     "/usr/bin:/bin",
+			}
+		})
+
+		It("should mount the cli tools image instead of adding an initContainer", func() {
+			pod := makePod("test-pod-image-volume", 2, nil)
+			injector.Inject(pod, config, nil)
+
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+			Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+				Name: CliToolsVolumeName,
+				VolumeSource: corev1.VolumeSource{Image: &corev1.ImageVolumeSource{
+					Reference:  defaultCliToolsImage,
+					PullPolicy: corev1.PullIfNotPresent,
+				}},
+			}))
+			toolsPath := defaultMountPath + defaultCliToolsDir
+			for _, c := range pod.Spec.Containers {
+				Expect(c.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+					Name:      CliToolsVolumeName,
+					MountPath: defaultMountPath,
+					ReadOnly:  true,
+				}))
+				Expect(c.Env).To(ConsistOf(
+					corev1.EnvVar{Name: CliToolsPathEnvName, Value: toolsPath},
+					corev1.EnvVar{Name: PathEnvName, Value: toolsPath + ":/usr/bin:/bin"},
+				))
+			}
+		})
+
+		It("should warn that the tools selection is ignored", func() {
+			config.CliTools = []string{"dfget"}
+			warnings, err := injector.Admit(makePod("test-pod-image-volume-tools", 1, nil), config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("cli tools selection is ignored")))
+		})
+
+		DescribeTable("should resolve the auto mode with the server version",
+			func(gitVersion string, minVersion string, expected string) {
+				config.CliToolsDeliveryMode = CliToolsDeliveryModeAuto
+				config.ImageVolumeMinVersion = minVersion
+				nsInfo := &NamespaceInfo{}
+				if gitVersion != "" {
+					nsInfo.ServerVersion = &version.Info{GitVersion: gitVersion}
+				}
+				effective := EffectiveConfig(config, makePod("test-pod-auto", 1, nil), nsInfo)
+				Expect(effective.CliToolsDeliveryMode).To(Equal(expected))
+				Expect(config.CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeAuto))
+			},
+			Entry("unknown server version", "", "", CliToolsDeliveryModeInitContainer),
+			Entry("old server", "v1.33.2", "", CliToolsDeliveryModeInitContainer),
+			Entry("supporting server", "v1.35.0", "", CliToolsDeliveryModeImageVolume),
+			Entry("vendor server version", "v1.36.1-gke.1200", "", CliToolsDeliveryModeImageVolume),
+			Entry("configured min version", "v1.33.2", "v1.33", CliToolsDeliveryModeImageVolume),
+			Entry("invalid server version", "unknown", "", CliToolsDeliveryModeInitContainer),
+		)
+
+		It("should reject invalid delivery config", func() {
+			config.CliToolsDeliveryMode = "Copy"
+			Expect(config.validate()).To(MatchError(ContainSubstring("invalid cli tools delivery mode")))
+			config.CliToolsDeliveryMode = CliToolsDeliveryModeAuto
+			config.ImageVolumeMinVersion = "latest"
+			Expect(config.validate()).To(MatchError(ContainSubstring("invalid image volume min version")))
+		})
+	})
+
+	Describe("Node cache delivery", func() {
+		const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath:      defaultCliToolsDir,
+				CliToolsImage:        "dragonflyoss/cli-tools:v2.1.0",
+				CliToolsDeliveryMode: CliToolsDeliveryModeHostPath,
+			}
+		})
+
+		It("should mount the version directory of the node cache read-only", func() {
+			pod := makePod("test-pod-host-path", 2, nil)
+			injector.Inject(pod, config, nil)
+
+			Expect(pod.Spec.InitContainers).To(BeEmpty())
+			Expect(pod.Spec.Volumes).To(ConsistOf(corev1.Volume{
+				Name: CliToolsVolumeName,
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{
+					Path: DefaultCliToolsHostPath + "/v2.1.0",
+					Type: ptr.To(corev1.HostPathDirectory),
+				}},
+			}))
+			for _, c := range pod.Spec.Containers {
+				Expect(c.VolumeMounts).To(ConsistOf(corev1.VolumeMount{
+					Name:      CliToolsVolumeName,
+					MountPath: defaultMountPath,
+					ReadOnly:  true,
+				}))
+				Expect(c.Env).To(ConsistOf(corev1.EnvVar{Name: CliToolsPathEnvName, Value: defaultMountPath}))
+			}
+		})
+
+		DescribeTable("should key the node cache by the image version",
+			func(image string, expected string) {
+				config.CliToolsHostPath = "/data/d7y-tools"
+				pod := makePod("test-pod-host-path-key", 1, map[string]string{CliToolsImageAnnotation: image})
+				injector.Inject(pod, config, nil)
+				Expect(pod.Spec.Volumes[0].HostPath.Path).To(Equal(expected))
+			},
+			Entry("tag", "harbor.internal/dragonfly/cli-tools:v2.0.9", "/data/d7y-tools/v2.0.9"),
+			Entry("digest", "dragonflyoss/cli-tools:v2.0.9@"+digest, "/data/d7y-tools/"+strings.ReplaceAll(digest, ":", "-")),
+			Entry("no tag", "dragonflyoss/cli-tools", "/data/d7y-tools/latest"),
+		)
+
+		It("should copy the tools of versions other than the configured one", func() {
+			pod := makePod("test-pod-host-path-version", 1, map[string]string{CliToolsImageAnnotation: "dragonflyoss/cli-tools:v2.0.9"})
+			Expect(EffectiveConfig(config, pod, nil).CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeInitContainer))
+
+			By("keeping the node cache for another image of the configured version")
+			pod.Annotations[CliToolsImageAnnotation] = "harbor.internal/dragonfly/cli-tools:v2.1.0"
+			Expect(EffectiveConfig(config, pod, nil).CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeHostPath))
+		})
+
+		It("should warn that the latest version is never refreshed", func() {
+			pod := makePod("test-pod-host-path-latest", 1, nil)
+			Expect(injector.Admit(pod, config, nil)).To(BeEmpty())
+			config.CliToolsImage = "dragonflyoss/cli-tools:latest"
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring(`cli tools version "latest" is never refreshed`)))
+		})
+
+		It("should copy the tools in namespaces forbidding hostPath volumes", func() {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "restricted",
+				Labels: map[string]string{PodSecurityEnforceLabelName: PodSecurityLevelRestricted},
+			}}
+			effective := EffectiveConfig(config, makePod("test-pod-host-path-pss", 1, nil), &NamespaceInfo{Namespace: ns})
+			Expect(effective.CliToolsDeliveryMode).To(Equal(CliToolsDeliveryModeInitContainer))
+		})
+
+		It("should reject a relative host path", func() {
+			config.CliToolsHostPath = "var/lib/dragonfly"
+			Expect(config.validate()).To(MatchError(ContainSubstring("is not absolute")))
+		})
+	})
+
+	Describe("PATH", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath:   defaultCliToolsDir,
+				CliToolsImage:     defaultCliToolsImage,
+				CliToolsAddToPath: true,
+			}
+		})
+
+		findPath := func(c corev1.Container) []corev1.EnvVar {
+			var envs []corev1.EnvVar
+			for _, env := range c.Env {
+				if env.Name == PathEnvName {
+					envs = append(envs, env)
+				}
+			}
+			return envs
+		}
+
+		It("should not change PATH unless enabled", func() {
+			config.CliToolsAddToPath = false
+			pod := makePod("test-pod-no-path", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(findPath(pod.Spec.Containers[0])).To(BeEmpty())
+		})
+
+		It("should keep the image PATH of containers without a PATH env", func() {
+			pod := makePod("test-pod-image-path", 2, nil)
+			pod.Spec.Containers[1].Env = []corev1.EnvVar{{Name: PathEnvName, Value: "/usr/bin"}}
+			injector.Inject(pod, config, nil)
+			Expect(findPath(pod.Spec.Containers[0])).To(BeEmpty())
+			Expect(findPath(pod.Spec.Containers[1])).To(ConsistOf(corev1.EnvVar{
+				Name:  PathEnvName,
+				Value: defaultMountPath + ":/usr/bin",
+			}))
+
+			By("warning about the containers without a PATH env")
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("containers without a PATH env: " + pod.Spec.Containers[0].Name)))
+
+			config.CliToolsBasePath = "/usr/bin:/bin"
+			Expect(injector.Admit(pod, config, nil)).To(BeEmpty())
+		})
+
+		It("should prepend the tools directory to the configured base PATH", func() {
+			config.CliToolsBasePath = "/opt/app/bin:/usr/bin:/bin"
+			pod := makePod("test-pod-base-path", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(findPath(pod.Spec.Containers[0])).To(ConsistOf(corev1.EnvVar{
+				Name:  PathEnvName,
+				Value: defaultMountPath + ":/opt/app/bin:/usr/bin:/bin",
+			}))
+		})
+
+		It("should keep the PATH set by the container", func() {
+			pod := makePod("test-pod-container-path", 1, nil)
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: PathEnvName, Value: "/app/bin:/usr/bin"}}
+
+			By("injecting twice")
+			injector.Inject(pod, config, nil)
+			injector.Inject(pod, config, nil)
+
+			Expect(findPath(pod.Spec.Containers[0])).To(ConsistOf(corev1.EnvVar{
+				Name:  PathEnvName,
+				Value: defaultMountPath + ":/app/bin:/usr/bin",
+			}))
+		})
+
+		It("should not change a PATH that is not a literal value", func() {
+			pathFrom := corev1.EnvVar{
+				Name: PathEnvName,
+				ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "env"},
+					Key:                  "path",
+				}},
+			}
+			pod := makePod("test-pod-path-from", 1, nil)
+			pod.Spec.Containers[0].Env = []corev1.EnvVar{pathFrom}
+			injector.Inject(pod, config, nil)
+			Expect(findPath(pod.Spec.Containers[0])).To(ConsistOf(pathFrom))
+		})
+	})
+
+	Describe("Architecture images", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath: defaultCliToolsDir,
+				CliToolsImage:   defaultCliToolsImage,
+				CliToolsArchImages: map[string]string{
+					"amd64": "mirror/cli-tools-amd64-linux:v1",
+					"arm64": "mirror/cli-tools-arm64-linux:v1",
+				},
+			}
+		})
+
+		archTerm := func(operator corev1.NodeSelectorOperator, archs ...string) corev1.NodeSelectorTerm {
+			return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: corev1.LabelArchStable, Operator: operator, Values: archs},
+			}}
+		}
+		withAffinity := func(pod *corev1.Pod, terms ...corev1.NodeSelectorTerm) *corev1.Pod {
+			pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+			}}
+			return pod
+		}
+		withNodeSelector := func(pod *corev1.Pod, arch string) *corev1.Pod {
+			pod.Spec.NodeSelector = map[string]string{corev1.LabelArchStable: arch}
+			return pod
+		}
+
+		DescribeTable("should select the image of the pod's architecture",
+			func(pod *corev1.Pod, expected string, warning string) {
+				warnings, err := injector.Admit(pod, config, nil)
+				Expect(err).NotTo(HaveOccurred())
+				if warning == "" {
+					Expect(warnings).To(BeEmpty())
+				} else {
+					Expect(warnings).To(ConsistOf(ContainSubstring(warning)))
+				}
+
+				// an empty expected image is the configured image
+				if expected == "" {
+					expected = defaultCliToolsImage
+				}
+				injector.Inject(pod, config, nil)
+				Expect(pod.Spec.InitContainers[0].Image).To(Equal(expected))
+			},
+			Entry("nodeSelector", withNodeSelector(makePod("arch-selector", 1, nil), "arm64"),
+				"mirror/cli-tools-arm64-linux:v1", ""),
+			Entry("required node affinity", withAffinity(makePod("arch-affinity", 1, nil), archTerm(corev1.NodeSelectorOpIn, "amd64")),
+				"mirror/cli-tools-amd64-linux:v1", ""),
+			Entry("affinity narrowed by nodeSelector", withNodeSelector(withAffinity(makePod("arch-both", 1, nil),
+				archTerm(corev1.NodeSelectorOpIn, "amd64", "arm64")), "arm64"),
+				"mirror/cli-tools-arm64-linux:v1", ""),
+			Entry("terms with the same architecture", withAffinity(makePod("arch-terms", 1, nil),
+				archTerm(corev1.NodeSelectorOpIn, "arm64"), archTerm(corev1.NodeSelectorOpIn, "arm64")),
+				"mirror/cli-tools-arm64-linux:v1", ""),
+			Entry("unconstrained pod", makePod("arch-none", 1, nil),
+				"", "doesn't select a single kubernetes.io/arch"),
+			Entry("multiple architectures", withAffinity(makePod("arch-multiple", 1, nil), archTerm(corev1.NodeSelectorOpIn, "arm64", "amd64")),
+				"", "can run on architectures [amd64 arm64]"),
+			Entry("term without an architecture", withAffinity(makePod("arch-or", 1, nil),
+				archTerm(corev1.NodeSelectorOpIn, "arm64"), archTerm(corev1.NodeSelectorOpExists)),
+				"", "doesn't select a single"),
+			Entry("NotIn expression", withAffinity(makePod("arch-not-in", 1, nil), archTerm(corev1.NodeSelectorOpNotIn, "amd64")),
+				"", "doesn't select a single"),
+			Entry("architecture without an image", withNodeSelector(makePod("arch-s390x", 1, nil), "s390x"),
+				defaultCliToolsImage, `no cli tools image is configured for architecture "s390x"`),
+		)
+
+		It("should reject ambiguous pods when configured", func() {
+			config.CliToolsArchAction = ImagePolicyActionReject
+			_, err := injector.Admit(makePod("arch-reject", 1, nil), config, nil)
+			Expect(err).To(MatchError(ContainSubstring("cannot select the cli tools image")))
+		})
+
+		It("should prefer the annotated image", func() {
+			pod := makePod("arch-annotation", 1, map[string]string{CliToolsImageAnnotation: annotationImage})
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal(annotationImage))
+		})
+
+		It("should fall back to the architecture image when the annotation is disallowed", func() {
+			config.CliToolsImagePolicy = &CliToolsImagePolicy{AllowedRegistries: []string{"harbor.internal"}}
+			pod := withNodeSelector(makePod("arch-annotation-disallowed", 1,
+				map[string]string{CliToolsImageAnnotation: annotationImage}), "amd64")
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring(`using "mirror/cli-tools-amd64-linux:v1"`)))
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal("mirror/cli-tools-amd64-linux:v1"))
+		})
+
+		It("should pass the architecture image through the effective config", func() {
+			pod := withNodeSelector(makePod("arch-effective", 1, nil), "arm64")
+			Expect(EffectiveConfig(config, pod, nil).CliToolsImage).To(Equal("mirror/cli-tools-arm64-linux:v1"))
+		})
+	})
+
+	Describe("Image rewrites", func() {
+		var config *InjectConf
+
+		BeforeEach(func() {
+			config = &InjectConf{
+				CliToolsDirPath: defaultCliToolsDir,
+				CliToolsImage:   "dragonflyoss/cli-tools:latest",
+				ImageRewrites:   map[string]string{"docker.io/dragonflyoss": "harbor.internal/dragonfly"},
+			}
+		})
+
+		It("should rewrite the default image", func() {
+			pod := makePod("test-pod-rewrite-default", 1, nil)
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal("harbor.internal/dragonfly/cli-tools:latest"))
+		})
+
+		It("should rewrite the annotated image after checking the image policy", func() {
+			config.CliToolsImagePolicy = &CliToolsImagePolicy{AllowedRepositories: []string{"dragonflyoss/*"}}
+			config.compile()
+			pod := makePod("test-pod-rewrite-annotation", 1, map[string]string{
+				CliToolsImageAnnotation: "docker.io/dragonflyoss/cli-tools:v2.1.0",
+			})
+
+			warnings, err := injector.Admit(pod, config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal("harbor.internal/dragonfly/cli-tools:v2.1.0"))
+		})
+
+		It("should keep images that don't match a rule", func() {
+			pod := makePod("test-pod-rewrite-other", 1, map[string]string{CliToolsImageAnnotation: annotationImage})
+			injector.Inject(pod, config, nil)
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.InitContainers[0].Image).To(Equal(annotationImage))
+		})
+	})
+
 	Describe("CheckFunctions", func() {
 		var (
 			injectedPod *corev1.Pod
@@ -8,50 +8,27 @@ func NewUnixSocketInjector() *UnixSocketInjector {
 	return &UnixSocketInjector{}
 }
 
-func (usi *UnixSocketInjector) Inject(pod *corev1.Pod, config *InjectConf) {
+func (usi *UnixSocketInjector) Inject(pod *corev1.Pod, config *InjectConf, admission *PodAdmission) {
 	podlog.Info("UnixSocketInjector Inject")
-
-	// check volume exsit
-	volumeExsit := false
-	for _, v := range pod.Spec.Volumes {
-		if v.Name == DfdaemonUnixSockVolumeName {
-			volumeExsit = true
-			podlog.Info("volume exsit", "volume name", v.Name)
-			break
-		}
+	if config.DisableUnixSocket {
+		podlog.Info("UnixSocketInjector disabled, skip inject")
+		return
 	}
-	if !volumeExsit {
-		hostPathType := corev1.HostPathSocket
-		dfdaemonSocketVolume := corev1.Volume{
-			Name: DfdaemonUnixSockVolumeName,
-			VolumeSource: corev1.VolumeSource{
-				HostPath: &corev1.HostPathVolumeSource{
-					Path: DfdaemonUnixSockPath,
-					Type: &hostPathType,
-				},
+
+	hostPathType := corev1.HostPathSocket
+	addVolume(config, admission, pod, corev1.Volume{
+		Name: DfdaemonUnixSockVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: DfdaemonUnixSockPath,
+				Type: &hostPathType,
 			},
-		}
-		pod.Spec.Volumes = append(pod.Spec.Volumes, dfdaemonSocketVolume)
-	}
+		},
+	})
 	for i := range pod.Spec.Containers {
-		usi.InjectContainer(&pod.Spec.Containers[i])
-	}
-}
-
-func (usi *UnixSocketInjector) InjectContainer(c *corev1.Container) {
-	// check volumeMount exsit
-	exsit := false
-	for _, v := range c.VolumeMounts {
-		if v.Name == DfdaemonUnixSockVolumeName {
-			exsit = true
-			break
-		}
-	}
-	if !exsit {
-		dfdaemonSocketVolumeMount := corev1.VolumeMount{
+		addVolumeMount(config, admission, &pod.Spec.Containers[i], corev1.VolumeMount{
 			Name:      DfdaemonUnixSockVolumeName,
 			MountPath: DfdaemonUnixSockPath,
-		}
-		c.VolumeMounts = append(c.VolumeMounts, dfdaemonSocketVolumeMount)
+		})
 	}
 }
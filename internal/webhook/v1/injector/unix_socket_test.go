@@ -60,7 +60,7 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
@@ -88,7 +88,7 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
@@ -130,7 +130,7 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
@@ -168,7 +168,7 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
@@ -194,7 +194,7 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
@@ -238,10 +238,26 @@ var _ = Describe("UnixSocketInjector", func() {
 			}
 
 			By("performing injection")
-			injector.Inject(pod, &InjectConf{})
+			injector.Inject(pod, &InjectConf{}, nil)
 
 			By("verifying the result")
 			Expect(pod).To(Equal(expectedPod))
 		})
 	})
+
+	Context("when the unix socket is disabled", func() {
+		It("should not inject the volume or mounts", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pod-disabled"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "container-1"}},
+				},
+			}
+			expectedPod := pod.DeepCopy()
+
+			injector.Inject(pod, &InjectConf{DisableUnixSocket: true}, nil)
+
+			Expect(pod).To(Equal(expectedPod))
+		})
+	})
 })
@@ -0,0 +1,41 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"slices"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ensureMavenSettings creates or updates the Maven settings ConfigMap in the pod namespace when the pod
+// enables the Maven mirror. Dry-run requests are skipped since they must not have side effects.
+func (d *PodCustomDefaulter) ensureMavenSettings(
+	ctx context.Context, pod *corev1.Pod, config *injector.InjectConf, podAdmission *injector.PodAdmission,
+) error {
+	if ecosystems, _ := injector.MirrorsForPod(config, pod); !slices.Contains(ecosystems, injector.MirrorEcosystemMaven) {
+		return nil
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.DryRun != nil && *req.DryRun {
+		podlog.Info("skip creating maven settings for dry-run request", "pod", pod.Name)
+		return nil
+	}
+	return d.copyIntoNamespace(ctx, injector.MavenSettingsConfigMap(config, podAdmission), pod.GetNamespace())
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Maven settings", func() {
+	var (
+		ctx        context.Context
+		defaulter  *PodCustomDefaulter
+		fakeClient client.Client
+		config     *injector.InjectConf
+		pod        *corev1.Pod
+		key        client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = injector.NewDefaultInjectConf()
+		config.EcosystemMirrors = &injector.EcosystemMirrors{Maven: "/maven2"}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-pod",
+			Namespace:   "app",
+			Annotations: map[string]string{injector.MirrorsAnnotation: "maven"},
+		}}
+		key = client.ObjectKey{Namespace: "app", Name: injector.MavenSettingsConfigMapName}
+
+		defaulter, fakeClient = newFakeDefaulter()
+	})
+
+	It("should create and update the settings in the pod namespace", func() {
+		Expect(defaulter.ensureMavenSettings(ctx, pod, config, nil)).To(Succeed())
+		settings := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, key, settings)).To(Succeed())
+		Expect(settings.Labels).To(HaveKeyWithValue(ManagedByLabelName, ManagedByLabelValue))
+		Expect(settings.Data).To(Equal(injector.MavenSettingsConfigMap(config, nil).Data))
+
+		By("changing the mirror")
+		config.EcosystemMirrors.Maven = "/maven-central"
+		Expect(defaulter.ensureMavenSettings(ctx, pod, config, nil)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, settings)).To(Succeed())
+		Expect(settings.Data[injector.MavenSettingsFileName]).To(ContainSubstring("/maven-central"))
+	})
+
+	It("should not create the settings for pods without the maven mirror", func() {
+		pod.Annotations[injector.MirrorsAnnotation] = "pip"
+		Expect(defaulter.ensureMavenSettings(ctx, pod, config, nil)).To(Succeed())
+		err := fakeClient.Get(ctx, key, &corev1.ConfigMap{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
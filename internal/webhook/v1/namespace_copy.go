@@ -0,0 +1,107 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// copyIntoNamespace creates or updates the copy of the source Secret or ConfigMap in the namespace, under
+// the same name. Existing objects not labeled as managed by the webhook are never overwritten.
+func (d *PodCustomDefaulter) copyIntoNamespace(ctx context.Context, source client.Object, nsName string) error {
+	kind := objectKind(source)
+	target := source.DeepCopyObject().(client.Object)
+	err := d.kubeClient.Get(ctx, client.ObjectKey{Namespace: nsName, Name: source.GetName()}, target)
+	if apierrors.IsNotFound(err) {
+		target = newNamespaceCopy(source, nsName)
+		if err := d.kubeClient.Create(ctx, target); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create %s %s/%s: %w", kind, nsName, source.GetName(), err)
+		}
+		podlog.Info("copied object into pod namespace", "kind", kind, "namespace", nsName, "name", source.GetName())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s/%s: %w", kind, nsName, source.GetName(), err)
+	}
+
+	if target.GetLabels()[ManagedByLabelName] != ManagedByLabelValue {
+		podlog.Info("object already exists and is not managed by the webhook, keep it",
+			"kind", kind, "namespace", nsName, "name", source.GetName())
+		return nil
+	}
+	if !copyObjectData(target, source) {
+		return nil
+	}
+	if err := d.kubeClient.Update(ctx, target); err != nil {
+		return fmt.Errorf("failed to update %s %s/%s: %w", kind, nsName, source.GetName(), err)
+	}
+	podlog.Info("updated object in pod namespace", "kind", kind, "namespace", nsName, "name", source.GetName())
+	return nil
+}
+
+// newNamespaceCopy returns a copy of the data of the source Secret or ConfigMap in the namespace,
+// labeled as managed by the webhook.
+func newNamespaceCopy(source client.Object, nsName string) client.Object {
+	meta := metav1.ObjectMeta{
+		Name:      source.GetName(),
+		Namespace: nsName,
+		Labels:    map[string]string{ManagedByLabelName: ManagedByLabelValue},
+	}
+	switch source := source.(type) {
+	case *corev1.Secret:
+		return &corev1.Secret{ObjectMeta: meta, Type: source.Type, Data: source.Data}
+	case *corev1.ConfigMap:
+		return &corev1.ConfigMap{ObjectMeta: meta, Data: source.Data, BinaryData: source.BinaryData}
+	}
+	panic(fmt.Sprintf("unsupported object type %T", source))
+}
+
+// objectKind returns the kind of the Secret or ConfigMap for logs and errors.
+func objectKind(obj client.Object) string {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return "secret"
+	}
+	return "configmap"
+}
+
+// copyObjectData copies the data of the source Secret or ConfigMap into the target, and reports
+// whether it changed.
+func copyObjectData(target, source client.Object) bool {
+	switch source := source.(type) {
+	case *corev1.Secret:
+		target := target.(*corev1.Secret)
+		if equality.Semantic.DeepEqual(target.Data, source.Data) {
+			return false
+		}
+		target.Data = source.Data
+	case *corev1.ConfigMap:
+		target := target.(*corev1.ConfigMap)
+		if equality.Semantic.DeepEqual(target.Data, source.Data) &&
+			equality.Semantic.DeepEqual(target.BinaryData, source.BinaryData) {
+			return false
+		}
+		target.Data, target.BinaryData = source.Data, source.BinaryData
+	}
+	return true
+}
@@ -13,20 +13,24 @@ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 See the License for the specific language governing permissions and
 limitations under the License.
 */
-// +kubebuilder:rbac:groups="",resources=namespaces;pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=limitranges;namespaces;nodes;pods,verbs=get;list;watch
 package v1
 
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // nolint:unused
@@ -41,17 +45,36 @@ func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
 	}
 
 	defaulter := NewPodCustomDefaulter(mgr.GetClient(), configManager)
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	defaulter.serverVersion = newServerVersionCache(discoveryClient)
+	if err := mgr.AddMetricsServerExtraHandler(ConfigDebugPath, NewConfigDebugHandler(defaulter)); err != nil {
+		return fmt.Errorf("failed to add config debug handler to manager: %w", err)
+	}
 
-	return ctrl.NewWebhookManagedBy(mgr).For(&corev1.Pod{}).
-		WithDefaulter(defaulter).
-		Complete()
+	// The defaulter webhook is registered by hand, so its handler can be wrapped to return warnings.
+	podWebhook := admission.WithCustomDefaulter(mgr.GetScheme(), &corev1.Pod{}, defaulter)
+	podWebhook.Handler = &warningHandler{Handler: podWebhook.Handler}
+	mgr.GetWebhookServer().Register(PodMutatingWebhookPath, podWebhook)
+	return nil
 }
 
 type Injector interface {
-	Inject(pod *corev1.Pod, config *injector.InjectConf)
+	Inject(pod *corev1.Pod, config *injector.InjectConf, admission *injector.PodAdmission)
 }
 
-// +kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod-v1.d7y.io,admissionReviewVersions=v1
+// Admitter is implemented by injectors that check a pod before any injector mutates it.
+// The warnings are returned to the client, an error rejects the pod.
+type Admitter interface {
+	Admit(pod *corev1.Pod, config *injector.InjectConf, admission *injector.PodAdmission) ([]string, error)
+}
+
+// PodMutatingWebhookPath is the path of the pod webhook, it must match the kubebuilder:webhook marker.
+const PodMutatingWebhookPath string = "/mutate--v1-pod"
+
+// +kubebuilder:webhook:path=/mutate--v1-pod,mutating=true,failurePolicy=fail,sideEffects=NoneOnDryRun,groups="",resources=pods,verbs=create;update,versions=v1,name=mpod-v1.d7y.io,admissionReviewVersions=v1
 
 // PodCustomDefaulter struct is responsible for setting default values on the custom resource of the
 // Kind Pod when those are created or updated.
@@ -62,18 +85,29 @@ type PodCustomDefaulter struct {
 	configManager *injector.ConfigManager
 	kubeClient    client.Client
 	injectors     []Injector
+	// digestResolver pins the cli tools image to a digest, see InjectConf.CliToolsImageResolution
+	digestResolver *injector.DigestResolver
+	// serverVersion is only needed for CliToolsDeliveryModeAuto, the version is unknown when nil
+	serverVersion *serverVersionCache
+	// hostIPFamily is only needed for ProxyHostHostIP, for pods not bound to a node at creation
+	hostIPFamily *hostIPFamilyCache
 }
 
 var _ webhook.CustomDefaulter = &PodCustomDefaulter{}
 
 func NewPodCustomDefaulter(c client.Client, configManager *injector.ConfigManager) *PodCustomDefaulter {
 	return &PodCustomDefaulter{
-		kubeClient:    c,
-		configManager: configManager,
+		kubeClient:     c,
+		configManager:  configManager,
+		digestResolver: injector.NewDigestResolver(http.DefaultClient),
+		hostIPFamily:   newHostIPFamilyCache(c),
 		injectors: []Injector{
 			injector.NewProxyEnvInjector(),
 			injector.NewUnixSocketInjector(),
 			injector.NewToolsInitcontainerInjector(),
+			injector.NewCABundleInjector(),
+			injector.NewMirrorsInjector(),
+			injector.NewImageBuilderInjector(),
 		},
 	}
 }
@@ -87,21 +121,147 @@ func (d *PodCustomDefaulter) Default(ctx context.Context, obj runtime.Object) er
 	}
 	podlog.Info("Defaulting for Pod", "name", pod.GetName())
 
-	d.applyDefaults(ctx, pod)
-	return nil
+	return d.applyDefaults(ctx, pod)
 }
 
-func (d *PodCustomDefaulter) applyDefaults(ctx context.Context, pod *corev1.Pod) {
-	config := d.configManager.GetConfig()
+func (d *PodCustomDefaulter) applyDefaults(ctx context.Context, pod *corev1.Pod) error {
+	// the containers and volumes of existing pods can't change, the API server rejects an injected update
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.Operation == admissionv1.Update {
+		podlog.Info("Pod not inject, updated pods are never injected", "name", pod.GetName())
+		return nil
+	}
 	// check if need inject
 	if !d.injectRequired(ctx, pod) {
 		podlog.Info("Pod not inject", "name", pod.GetName())
-		return
+		return nil
+	}
+	loaded := d.configManager.GetConfig()
+	if injector.SkipHostNetworkPod(loaded, pod) {
+		podlog.Info("Pod not inject, hostNetwork pods are skipped", "name", pod.GetName())
+		return nil
+	}
+	config, podAdmission := d.effectiveConfig(ctx, loaded, pod)
+
+	// admit the pod before mutating it, so a rejected pod is never partially injected
+	for _, ij := range d.injectors {
+		admitter, ok := ij.(Admitter)
+		if !ok {
+			continue
+		}
+		warnings, err := admitter.Admit(pod, config, podAdmission)
+		addAdmissionWarnings(ctx, warnings...)
+		if err != nil {
+			podlog.Info("Pod rejected", "name", pod.GetName(), "reason", err.Error())
+			return err
+		}
+	}
+
+	config, warning := injector.CheckDfdaemonCompatibility(config, podAdmission, pod, d.dfdaemonVersion(ctx, config))
+	if warning != "" {
+		addAdmissionWarnings(ctx, warning)
+	}
+	if warning := d.checkProxyService(ctx, config); warning != "" {
+		addAdmissionWarnings(ctx, warning)
+	}
+
+	warning, err := d.digestResolver.PinCliToolsImage(ctx, config, podAdmission, pod, d.cliToolsPullSecrets(pod, config))
+	if err != nil {
+		podlog.Info("Pod rejected", "name", pod.GetName(), "reason", err.Error())
+		return err
+	}
+	if warning != "" {
+		addAdmissionWarnings(ctx, warning)
+	}
+
+	// inject a copy, so a pod rejected for its conflicts is left as it was
+	injected := pod.DeepCopy()
+	d.inject(injected, config, podAdmission)
+	warnings, err := injector.CheckConflicts(pod, config, podAdmission)
+	addAdmissionWarnings(ctx, warnings...)
+	if err != nil {
+		podlog.Info("Pod rejected", "name", pod.GetName(), "reason", err.Error())
+		return err
+	}
+
+	if err := d.ensureImagePullSecret(ctx, pod, config); err != nil {
+		podlog.Error(err, "failed to ensure cli tools pull secret", "pod", pod.Name)
+		addAdmissionWarnings(ctx, fmt.Sprintf("the pod may not start without its cli tools pull secret: %v", err))
+	}
+	if err := d.ensureProxyAuthSecret(ctx, pod, config); err != nil {
+		podlog.Error(err, "failed to ensure proxy auth secret", "pod", pod.Name)
+		addAdmissionWarnings(ctx, fmt.Sprintf("the pod may not start without its proxy auth secret: %v", err))
+	}
+	if err := d.ensureCABundle(ctx, pod, config); err != nil {
+		podlog.Error(err, "failed to ensure ca bundle", "pod", pod.Name)
+		addAdmissionWarnings(ctx, fmt.Sprintf("the pod may not start without its ca bundle: %v", err))
+	}
+	if err := d.ensureMavenSettings(ctx, pod, config, podAdmission); err != nil {
+		podlog.Error(err, "failed to ensure maven settings", "pod", pod.Name)
+		addAdmissionWarnings(ctx, fmt.Sprintf("the pod may not start without its maven settings: %v", err))
 	}
 	podlog.Info("Pod inject ")
+	*pod = *injected
+	return nil
+}
+
+// inject applies every injector to the pod.
+func (d *PodCustomDefaulter) inject(pod *corev1.Pod, config *injector.InjectConf, podAdmission *injector.PodAdmission) {
 	for _, ij := range d.injectors {
-		ij.Inject(pod, config)
+		ij.Inject(pod, config, podAdmission)
+	}
+}
+
+// effectiveConfig merges the loaded config with the pod's namespace and annotations, and starts the admission of the pod.
+func (d *PodCustomDefaulter) effectiveConfig(
+	ctx context.Context, config *injector.InjectConf, pod *corev1.Pod,
+) (*injector.InjectConf, *injector.PodAdmission) {
+	nsInfo := d.namespaceInfo(ctx, pod, config)
+	effective := injector.EffectiveConfig(config, pod, nsInfo)
+	return effective, injector.NewPodAdmission(effective, pod, nsInfo)
+}
+
+// namespaceInfo collects the state of the pod's namespace, and of the cluster, the effective config depends on.
+// Lookup failures are logged and leave the corresponding fields empty.
+func (d *PodCustomDefaulter) namespaceInfo(
+	ctx context.Context, pod *corev1.Pod, config *injector.InjectConf,
+) *injector.NamespaceInfo {
+	nsName := pod.GetNamespace()
+	nsInfo := &injector.NamespaceInfo{}
+
+	ns := &corev1.Namespace{}
+	if err := d.kubeClient.Get(ctx, client.ObjectKey{Name: nsName}, ns); err != nil {
+		podlog.Error(err, "failed to get namespace", "namespace", nsName)
+	} else {
+		nsInfo.Namespace = ns
+	}
+
+	if config.CliToolsResourcesFromLimitRange {
+		limitRanges := &corev1.LimitRangeList{}
+		if err := d.kubeClient.List(ctx, limitRanges, client.InNamespace(nsName)); err != nil {
+			podlog.Error(err, "failed to list limitranges", "namespace", nsName)
+		} else {
+			nsInfo.LimitRanges = limitRanges.Items
+		}
+	}
+
+	if config.CliToolsDeliveryMode == injector.CliToolsDeliveryModeAuto && d.serverVersion != nil {
+		nsInfo.ServerVersion = d.serverVersion.Get()
+	}
+
+	// the node IPs are only known for pods bound to a node at creation
+	if config.ProxyHost == injector.ProxyHostHostIP && pod.Spec.NodeName != "" {
+		node := &corev1.Node{}
+		if err := d.kubeClient.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil {
+			podlog.Error(err, "failed to get node", "node", pod.Spec.NodeName)
+		} else {
+			nsInfo.Node = node
+			nsInfo.HostIPFamily = injector.NodeHostIPFamily(node)
+		}
+	}
+	if config.ProxyHost == injector.ProxyHostHostIP && nsInfo.HostIPFamily == "" {
+		nsInfo.HostIPFamily = d.hostIPFamily.Get(ctx)
 	}
+	return nsInfo
 }
 
 func (d *PodCustomDefaulter) injectRequired(ctx context.Context, pod *corev1.Pod) bool {
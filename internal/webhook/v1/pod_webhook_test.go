@@ -18,35 +18,59 @@ package v1
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"gopkg.in/yaml.v3"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // mockInjector is a mock implementation of the Injector interface for testing purposes.
 // It records whether its Inject method has been called.
 type mockInjector struct {
-	called bool
-	config *injector.InjectConf
+	called    bool
+	config    *injector.InjectConf
+	admission *injector.PodAdmission
 }
 
-func (m *mockInjector) Inject(pod *corev1.Pod, config *injector.InjectConf) {
+func (m *mockInjector) Inject(pod *corev1.Pod, config *injector.InjectConf, admission *injector.PodAdmission) {
 	m.called = true
 	m.config = config
+	m.admission = admission
 }
 
 func (m *mockInjector) Reset() {
 	m.called = false
 	m.config = nil
+	m.admission = nil
+}
+
+// mockAdmitter is a mockInjector that also implements Admitter with fixed results.
+type mockAdmitter struct {
+	mockInjector
+	warnings []string
+	err      error
+}
+
+func (m *mockAdmitter) Admit(pod *corev1.Pod, config *injector.InjectConf, admission *injector.PodAdmission) ([]string, error) {
+	return m.warnings, m.err
 }
 
 var _ = Describe("Pod Webhook", func() {
@@ -75,6 +99,7 @@ var _ = Describe("Pod Webhook", func() {
 
 		// Write a predictable config file for the test
 		testConfig := &injector.InjectConf{
+			APIVersion:      injector.ConfigAPIVersion,
 			Enable:          true,
 			ProxyPort:       8001,
 			CliToolsImage:   "test/cli-tools:v1.0.0",
@@ -246,6 +271,270 @@ var _ = Describe("Pod Webhook", func() {
 			})
 		})
 
+		Context("and the config derives resources from the namespace LimitRange", func() {
+			It("should pass the LimitRange defaults to the injectors", func() {
+				By("writing a config that enables LimitRange defaults")
+				data := []byte("apiVersion: webhook.d7y.io/v1alpha2\ncliToolsResourcesFromLimitRange: true\n")
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+
+				By("creating a labeled namespace with a LimitRange")
+				labeledNs := &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: testNsName,
+						Labels: map[string]string{
+							injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue,
+						},
+					},
+				}
+				limitRange := &corev1.LimitRange{
+					ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: testNsName},
+					Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+						Type:    corev1.LimitTypeContainer,
+						Default: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+					}}},
+				}
+				setupDefaulter(labeledNs, limitRange)
+
+				By("calling the Default method")
+				err = defaulter.Default(ctx, testPod)
+				Expect(err).NotTo(HaveOccurred())
+
+				By("verifying the injector received the LimitRange defaults")
+				Expect(mockInj.called).To(BeTrue())
+				Expect(mockInj.config.CliToolsResources.Limits).To(Equal(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("200m"),
+				}))
+				Expect(mockInj.config.CliToolsResources.Requests).To(Equal(corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("200m"),
+				}))
+			})
+		})
+
+		Context("and the config selects the cli tools delivery mode automatically", func() {
+			It("should resolve the mode with the server version", func() {
+				data := []byte("apiVersion: webhook.d7y.io/v1alpha2\ncliToolsDeliveryMode: Auto\n")
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+
+				setupDefaulter(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   testNsName,
+					Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+				}})
+				defaulter.serverVersion = newServerVersionCache(&fakeServerVersion{info: &version.Info{GitVersion: "v1.35.1"}})
+
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				Expect(mockInj.called).To(BeTrue())
+				Expect(mockInj.config.CliToolsDeliveryMode).To(Equal(injector.CliToolsDeliveryModeImageVolume))
+			})
+		})
+
+		Context("and the config reaches the dfdaemon at the node IP", func() {
+			It("should pass the IP of the node the pod is bound to", func() {
+				data := []byte("apiVersion: webhook.d7y.io/v1alpha2\nproxyPort: 4001\nproxyHost: HostIP\nproxyIPFamily: IPv6\n")
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+
+				node := &corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+					Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+						{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+						{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+					}},
+				}
+				setupDefaulter(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   testNsName,
+					Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+				}}, node)
+				testPod.Spec.NodeName = "node-1"
+				testPod.Spec.Containers = []corev1.Container{{Name: "app"}}
+
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				Expect(mockInj.called).To(BeTrue())
+				injector.NewProxyEnvInjector().Inject(testPod, mockInj.config, mockInj.admission)
+				Expect(testPod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+					Name:  injector.ProxyEnvName,
+					Value: "http://[fd00::1]:$(" + injector.ProxyPortEnvName + ")",
+				}))
+
+				By("following the primary family of the cluster for pods that are not bound")
+				testPod.Spec.NodeName = ""
+				testPod.Spec.Containers = []corev1.Container{{Name: "app"}}
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				injector.NewProxyEnvInjector().Inject(testPod, mockInj.config, mockInj.admission)
+				Expect(testPod.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+					Name:  injector.ProxyEnvName,
+					Value: "http://$(" + injector.HostIPEnvName + "):$(" + injector.ProxyPortEnvName + ")",
+				}))
+				Expect(injector.NewProxyEnvInjector().Admit(testPod, mockInj.config, mockInj.admission)).To(
+					ConsistOf(ContainSubstring("instead of proxyIPFamily IPv6")))
+			})
+		})
+
+		Context("and the config skips hostNetwork pods", func() {
+			It("should not inject hostNetwork pods", func() {
+				data := []byte("apiVersion: webhook.d7y.io/v1alpha2\nhostNetwork:\n  skip: true\n")
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+				setupDefaulter(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   testNsName,
+					Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+				}})
+
+				testPod.Spec.HostNetwork = true
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				Expect(mockInj.called).To(BeFalse())
+
+				By("injecting pods in the pod network")
+				testPod.Spec.HostNetwork = false
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				Expect(mockInj.called).To(BeTrue())
+			})
+		})
+
+		Context("and the config pins the cli tools image to a digest", func() {
+			It("should reject the pod when the digest can't be resolved", func() {
+				By("writing a config with an unreachable registry")
+				registry := httptest.NewServer(http.NotFoundHandler())
+				registry.Close()
+				host := strings.TrimPrefix(registry.URL, "http://")
+				data := []byte("apiVersion: webhook.d7y.io/v1alpha2\ncliToolsImage: " + host + "/cli-tools:v1\n" +
+					"cliToolsImageResolution:\n  failurePolicy: Reject\n  insecureRegistries: [\"" + host + "\"]\n")
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+
+				setupDefaulter(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   testNsName,
+					Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+				}})
+
+				err = defaulter.Default(ctx, testPod)
+				Expect(err).To(MatchError(ContainSubstring("failed to resolve the digest of cli tools image")))
+				Expect(mockInj.called).To(BeFalse())
+			})
+		})
+
+		Context("and the config checks the cli tools version against dfdaemon", func() {
+			It("should inject the compatible cli tools tag in strict mode", func() {
+				data := []byte(`apiVersion: webhook.d7y.io/v1alpha2
+cliToolsImage: test/cli-tools:v1.0.0
+dfdaemonCompatibility:
+  daemonSetNamespace: dragonfly-system
+  daemonSetName: dragonfly-dfdaemon
+  strict: true
+  versions:
+  - dfdaemon: v2.1
+    cliTools: [v2.1]
+    cliToolsTag: v2.1.0
+`)
+				err := os.WriteFile(filepath.Join(tempDir, "config.yaml"), data, 0644)
+				Expect(err).NotTo(HaveOccurred())
+				configMgr = injector.NewConfigManager(tempDir)
+
+				Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+				setupDefaulter(
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+						Name:   testNsName,
+						Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+					}},
+					&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+						Name:      "dragonfly-dfdaemon",
+						Namespace: "dragonfly-system",
+						Labels:    map[string]string{injector.DefaultDfdaemonVersionLabel: "v2.1.3"},
+					}},
+				)
+
+				Expect(defaulter.Default(ctx, testPod)).To(Succeed())
+				Expect(mockInj.called).To(BeTrue())
+				Expect(injector.CliToolsImageForPod(mockInj.config, mockInj.admission, testPod)).To(Equal("docker.io/test/cli-tools:v2.1.0"))
+			})
+		})
+
+		Context("and the pod conflicts with the injected env vars", func() {
+			BeforeEach(func() {
+				setupDefaulter(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   testNsName,
+					Labels: map[string]string{injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue},
+				}})
+				defaulter.injectors = []Injector{injector.NewProxyEnvInjector()}
+				testPod.Annotations[injector.ConflictPolicyAnnotation] = "env=" + injector.ConflictPolicyReject
+				testPod.Spec.Containers = []corev1.Container{{
+					Name: "app",
+					Env:  []corev1.EnvVar{{Name: injector.ProxyPortEnvName, Value: "9001"}},
+				}}
+			})
+
+			It("should reject created pods without injecting them", func() {
+				err := defaulter.Default(ctx, testPod)
+				Expect(err).To(MatchError(ContainSubstring(`env "DRAGONFLY_PROXY_PORT" of container "app"`)))
+				Expect(testPod.Spec.Containers[0].Env).To(HaveLen(1))
+			})
+
+			It("should not inject or reject updated pods", func() {
+				updateCtx := admission.NewContextWithRequest(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Update,
+				}})
+				Expect(defaulter.Default(updateCtx, testPod)).To(Succeed())
+				Expect(testPod.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: injector.ProxyPortEnvName, Value: "9001"}}))
+			})
+		})
+
+		Context("and an injector admits the pod", func() {
+			var labeledNs *corev1.Namespace
+
+			BeforeEach(func() {
+				labeledNs = &corev1.Namespace{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: testNsName,
+						Labels: map[string]string{
+							injector.NamespaceInjectLabelName: injector.NamespaceInjectLabelValue,
+						},
+					},
+				}
+			})
+
+			It("should return the admission warnings and inject the pod", func() {
+				setupDefaulter(labeledNs)
+				admitter := &mockAdmitter{warnings: []string{"image ignored"}}
+				defaulter.injectors = []Injector{admitter, mockInj}
+
+				By("handling an admission request for the pod")
+				handler := admission.WithCustomDefaulter(scheme, &corev1.Pod{}, defaulter)
+				handler.Handler = &warningHandler{Handler: handler.Handler}
+				raw, err := json.Marshal(testPod)
+				Expect(err).NotTo(HaveOccurred())
+				resp := handler.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				}})
+
+				By("verifying the warnings are in the response")
+				Expect(resp.Allowed).To(BeTrue())
+				Expect(resp.Warnings).To(ConsistOf("image ignored"))
+				Expect(admitter.called).To(BeTrue())
+				Expect(mockInj.called).To(BeTrue())
+			})
+
+			It("should reject the pod without injecting it", func() {
+				setupDefaulter(labeledNs)
+				admitter := &mockAdmitter{err: errors.New("image not allowed")}
+				defaulter.injectors = []Injector{mockInj, admitter}
+
+				By("calling the Default method")
+				err := defaulter.Default(ctx, testPod)
+
+				By("verifying the pod is rejected before any injector runs")
+				Expect(err).To(MatchError("image not allowed"))
+				Expect(mockInj.called).To(BeFalse())
+				Expect(admitter.called).To(BeFalse())
+			})
+		})
+
 		Context("when the object is not a Pod", func() {
 			It("should return an error", func() {
 				By("creating a non-pod object")
@@ -0,0 +1,48 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ensureProxyAuthSecret copies the configured proxy auth Secret into the pod namespace, since
+// secretKeyRef env vars can only reference Secrets of the pod namespace. Dry-run requests are skipped
+// since they must not have side effects.
+func (d *PodCustomDefaulter) ensureProxyAuthSecret(ctx context.Context, pod *corev1.Pod, config *injector.InjectConf) error {
+	auth := config.ProxyAuth
+	nsName := pod.GetNamespace()
+	if auth == nil || auth.SecretNamespace == "" || auth.SecretNamespace == nsName {
+		return nil
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.DryRun != nil && *req.DryRun {
+		podlog.Info("skip copying proxy auth secret for dry-run request", "pod", pod.Name, "secret", auth.SecretName)
+		return nil
+	}
+
+	source := &corev1.Secret{}
+	if err := d.kubeClient.Get(ctx, client.ObjectKey{Namespace: auth.SecretNamespace, Name: auth.SecretName}, source); err != nil {
+		return fmt.Errorf("failed to get proxy auth secret %s/%s: %w", auth.SecretNamespace, auth.SecretName, err)
+	}
+	return d.copyIntoNamespace(ctx, source, nsName)
+}
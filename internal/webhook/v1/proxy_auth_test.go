@@ -0,0 +1,74 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Proxy auth secret copy", func() {
+	var (
+		ctx        context.Context
+		defaulter  *PodCustomDefaulter
+		fakeClient client.Client
+		config     *injector.InjectConf
+		pod        *corev1.Pod
+		source     *corev1.Secret
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = injector.NewDefaultInjectConf()
+		config.ProxyAuth = &injector.ProxyAuth{SecretNamespace: "dragonfly-system", SecretName: "proxy-auth"}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "app"}}
+		source = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "proxy-auth", Namespace: "dragonfly-system"},
+			Type:       corev1.SecretTypeBasicAuth,
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("dragonfly"),
+				corev1.BasicAuthPasswordKey: []byte("secret"),
+			},
+		}
+
+		defaulter, fakeClient = newFakeDefaulter(source)
+	})
+
+	It("should copy the secret into the pod namespace", func() {
+		Expect(defaulter.ensureProxyAuthSecret(ctx, pod, config)).To(Succeed())
+
+		target := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "proxy-auth"}, target)).To(Succeed())
+		Expect(target.Type).To(Equal(corev1.SecretTypeBasicAuth))
+		Expect(target.Data).To(Equal(source.Data))
+	})
+
+	It("should not copy secrets of the pod namespace", func() {
+		config.ProxyAuth.SecretNamespace = ""
+		Expect(defaulter.ensureProxyAuthSecret(ctx, pod, config)).To(Succeed())
+
+		secrets := &corev1.SecretList{}
+		Expect(fakeClient.List(ctx, secrets, client.InNamespace("app"))).To(Succeed())
+		Expect(secrets.Items).To(BeEmpty())
+	})
+})
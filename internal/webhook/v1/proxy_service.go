@@ -0,0 +1,45 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// checkProxyService returns a warning if the Service of the Service proxy mode doesn't exist, or may route
+// pods to the dfdaemon of another node. The Service is read through the manager cache.
+func (d *PodCustomDefaulter) checkProxyService(ctx context.Context, config *injector.InjectConf) string {
+	if config.ProxyMode != injector.ProxyModeService || config.ProxyService == nil {
+		return ""
+	}
+	key := client.ObjectKey{Namespace: config.ProxyService.Namespace, Name: config.ProxyService.Name}
+	svc := &corev1.Service{}
+	if err := d.kubeClient.Get(ctx, key, svc); err != nil {
+		podlog.Error(err, "failed to get proxy service", "service", key)
+		return fmt.Sprintf("dfdaemon proxy service %s is unavailable: %v", key, err)
+	}
+	if policy := svc.Spec.InternalTrafficPolicy; policy == nil || *policy != corev1.ServiceInternalTrafficPolicyLocal {
+		return fmt.Sprintf("dfdaemon proxy service %s doesn't set internalTrafficPolicy: Local, "+
+			"the pod may reach the dfdaemon of another node", key)
+	}
+	return ""
+}
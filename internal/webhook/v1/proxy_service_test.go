@@ -0,0 +1,71 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Proxy service check", func() {
+	var (
+		ctx    context.Context
+		config *injector.InjectConf
+		svc    *corev1.Service
+	)
+
+	check := func(initObjs ...client.Object) string {
+		defaulter, _ := newFakeDefaulter(initObjs...)
+		return defaulter.checkProxyService(ctx, config)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = injector.NewDefaultInjectConf()
+		config.ProxyMode = injector.ProxyModeService
+		config.ProxyService = &injector.ProxyService{Namespace: "dragonfly-system", Name: "dfdaemon"}
+		svc = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "dfdaemon", Namespace: "dragonfly-system"},
+			Spec:       corev1.ServiceSpec{InternalTrafficPolicy: ptr.To(corev1.ServiceInternalTrafficPolicyLocal)},
+		}
+	})
+
+	It("should accept a node local Service", func() {
+		Expect(check(svc)).To(BeEmpty())
+	})
+
+	It("should warn about a Service routing to other nodes", func() {
+		svc.Spec.InternalTrafficPolicy = ptr.To(corev1.ServiceInternalTrafficPolicyCluster)
+		Expect(check(svc)).To(ContainSubstring("doesn't set internalTrafficPolicy: Local"))
+	})
+
+	It("should warn about a missing Service", func() {
+		Expect(check()).To(ContainSubstring("dfdaemon proxy service dragonfly-system/dfdaemon is unavailable"))
+	})
+
+	It("should not check the node proxy mode", func() {
+		config.ProxyMode = ""
+		Expect(check()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,82 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;update
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// Label of the objects the webhook creates in pod namespaces
+	ManagedByLabelName  string = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue string = "dragonfly-p2p-webhook"
+)
+
+// ensureImagePullSecret copies the configured pull secret source into the pod namespace, so the
+// cli tools image can be pulled there. Secrets not created by the webhook are never overwritten,
+// and dry-run requests are skipped since they must not have side effects.
+func (d *PodCustomDefaulter) ensureImagePullSecret(ctx context.Context, pod *corev1.Pod, config *injector.InjectConf) error {
+	source := config.CliToolsImagePullSecretSource
+	nsName := pod.GetNamespace()
+	if source == nil || source.Name == "" || source.Namespace == nsName {
+		return nil
+	}
+	if source.Namespace == "" {
+		return fmt.Errorf("namespace of pull secret source %q is not set", source.Name)
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.DryRun != nil && *req.DryRun {
+		podlog.Info("skip copying pull secret for dry-run request", "pod", pod.Name, "secret", source.Name)
+		return nil
+	}
+
+	sourceSecret := &corev1.Secret{}
+	if err := d.kubeClient.Get(ctx, client.ObjectKey{Namespace: source.Namespace, Name: source.Name}, sourceSecret); err != nil {
+		return fmt.Errorf("failed to get pull secret source %s/%s: %w", source.Namespace, source.Name, err)
+	}
+	return d.copyIntoNamespace(ctx, sourceSecret, nsName)
+}
+
+// cliToolsPullSecrets returns the pull secrets of the cli tools image: the configured pull secrets of the
+// pod namespace and the pull secret source. Secrets that can't be read are skipped.
+func (d *PodCustomDefaulter) cliToolsPullSecrets(pod *corev1.Pod, config *injector.InjectConf) injector.PullSecretsFunc {
+	return func(ctx context.Context) []corev1.Secret {
+		keys := make([]client.ObjectKey, 0, len(config.CliToolsImagePullSecrets)+1)
+		for _, name := range config.CliToolsImagePullSecrets {
+			keys = append(keys, client.ObjectKey{Namespace: pod.GetNamespace(), Name: name})
+		}
+		if source := config.CliToolsImagePullSecretSource; source != nil && source.Name != "" && source.Namespace != "" {
+			keys = append(keys, client.ObjectKey{Namespace: source.Namespace, Name: source.Name})
+		}
+
+		secrets := make([]corev1.Secret, 0, len(keys))
+		for _, key := range keys {
+			secret := corev1.Secret{}
+			if err := d.kubeClient.Get(ctx, key, &secret); err != nil {
+				podlog.Error(err, "skip cli tools pull secret", "namespace", key.Namespace, "name", key.Name)
+				continue
+			}
+			secrets = append(secrets, secret)
+		}
+		return secrets
+	}
+}
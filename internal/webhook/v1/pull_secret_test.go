@@ -0,0 +1,130 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Image pull secret copy", func() {
+	var (
+		ctx        context.Context
+		defaulter  *PodCustomDefaulter
+		fakeClient client.Client
+		config     *injector.InjectConf
+		pod        *corev1.Pod
+		source     *corev1.Secret
+	)
+
+	getTarget := func() (*corev1.Secret, error) {
+		target := &corev1.Secret{}
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "registry"}, target)
+		return target, err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		config = injector.NewDefaultInjectConf()
+		config.CliToolsImagePullSecretSource = &corev1.SecretReference{Namespace: "dragonfly-system", Name: "registry"}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "app"}}
+		source = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "dragonfly-system"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		}
+	})
+
+	It("should copy the source secret into the pod namespace", func() {
+		defaulter, fakeClient = newFakeDefaulter(source)
+		Expect(defaulter.ensureImagePullSecret(ctx, pod, config)).To(Succeed())
+
+		target, err := getTarget()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Type).To(Equal(corev1.SecretTypeDockerConfigJson))
+		Expect(target.Data).To(Equal(source.Data))
+		Expect(target.Labels).To(HaveKeyWithValue(ManagedByLabelName, ManagedByLabelValue))
+	})
+
+	It("should update a managed copy when the source changes", func() {
+		managed := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "registry",
+				Namespace: "app",
+				Labels:    map[string]string{ManagedByLabelName: ManagedByLabelValue},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"old":{}}}`)},
+		}
+		defaulter, fakeClient = newFakeDefaulter(source, managed)
+		Expect(defaulter.ensureImagePullSecret(ctx, pod, config)).To(Succeed())
+
+		target, err := getTarget()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Data).To(Equal(source.Data))
+	})
+
+	It("should never overwrite a secret it doesn't manage", func() {
+		userSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "app"},
+			Data:       map[string][]byte{"user": []byte("data")},
+		}
+		defaulter, fakeClient = newFakeDefaulter(source, userSecret)
+		Expect(defaulter.ensureImagePullSecret(ctx, pod, config)).To(Succeed())
+
+		target, err := getTarget()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Data).To(Equal(userSecret.Data))
+	})
+
+	It("should not copy for dry-run requests", func() {
+		defaulter, fakeClient = newFakeDefaulter(source)
+		ctx = admission.NewContextWithRequest(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{DryRun: ptr.To(true)},
+		})
+		Expect(defaulter.ensureImagePullSecret(ctx, pod, config)).To(Succeed())
+
+		_, err := getTarget()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should report a missing source secret", func() {
+		defaulter, fakeClient = newFakeDefaulter()
+		Expect(defaulter.ensureImagePullSecret(ctx, pod, config)).To(MatchError(ContainSubstring("pull secret source")))
+	})
+
+	It("should read the pull secrets of the digest resolution", func() {
+		appSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "app-registry", Namespace: "app"}}
+		defaulter, fakeClient = newFakeDefaulter(source, appSecret)
+		config.CliToolsImagePullSecrets = []string{"app-registry", "missing"}
+
+		secrets := defaulter.cliToolsPullSecrets(pod, config)(ctx)
+		Expect(secrets).To(HaveLen(2))
+		Expect(secrets[0].Name).To(Equal("app-registry"))
+		Expect(secrets[1].Namespace).To(Equal("dragonfly-system"))
+	})
+})
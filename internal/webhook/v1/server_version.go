@@ -0,0 +1,60 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+)
+
+// serverVersionTTL is how long the API server version is cached, so upgrades are noticed without a restart.
+const serverVersionTTL = 10 * time.Minute
+
+// serverVersionCache caches the API server version for admission requests.
+type serverVersionCache struct {
+	client discovery.ServerVersionInterface
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	info      *version.Info
+	fetchedAt time.Time
+}
+
+func newServerVersionCache(client discovery.ServerVersionInterface) *serverVersionCache {
+	return &serverVersionCache{client: client, ttl: serverVersionTTL}
+}
+
+// Get returns the cached server version, fetching it when it expired. A failed fetch is logged
+// and returns the previous version, which is nil if the version was never fetched.
+func (c *serverVersionCache) Get() *version.Info {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.info != nil && time.Since(c.fetchedAt) < c.ttl {
+		return c.info
+	}
+	info, err := c.client.ServerVersion()
+	if err != nil {
+		podlog.Error(err, "failed to get server version")
+		return c.info
+	}
+	c.info, c.fetchedAt = info, time.Now()
+	return c.info
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// fakeServerVersion returns a fixed server version, or an error, and counts the calls.
+type fakeServerVersion struct {
+	info  *version.Info
+	err   error
+	calls int
+}
+
+func (f *fakeServerVersion) ServerVersion() (*version.Info, error) {
+	f.calls++
+	return f.info, f.err
+}
+
+var _ = Describe("serverVersionCache", func() {
+	It("should cache the server version until it expires", func() {
+		fake := &fakeServerVersion{info: &version.Info{GitVersion: "v1.35.0"}}
+		cache := newServerVersionCache(fake)
+
+		Expect(cache.Get().GitVersion).To(Equal("v1.35.0"))
+		Expect(cache.Get().GitVersion).To(Equal("v1.35.0"))
+		Expect(fake.calls).To(Equal(1))
+
+		By("fetching again after the TTL")
+		fake.info = &version.Info{GitVersion: "v1.36.0"}
+		cache.fetchedAt = time.Now().Add(-serverVersionTTL)
+		Expect(cache.Get().GitVersion).To(Equal("v1.36.0"))
+		Expect(fake.calls).To(Equal(2))
+	})
+
+	It("should keep the previous version when fetching fails", func() {
+		fake := &fakeServerVersion{err: errors.New("unavailable")}
+		cache := newServerVersionCache(fake)
+		Expect(cache.Get()).To(BeNil())
+
+		fake.info, fake.err = &version.Info{GitVersion: "v1.35.0"}, nil
+		Expect(cache.Get().GitVersion).To(Equal("v1.35.0"))
+
+		fake.err = errors.New("unavailable")
+		cache.fetchedAt = time.Now().Add(-serverVersionTTL)
+		Expect(cache.Get().GitVersion).To(Equal("v1.35.0"))
+	})
+})
@@ -29,11 +29,14 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"d7y.io/dragonfly-p2p-webhook/internal/webhook/v1/injector"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -142,6 +145,15 @@ var _ = AfterSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 })
 
+// newFakeDefaulter returns a defaulter with an empty config directory, and the fake client holding the
+// objects it reads.
+func newFakeDefaulter(initObjs ...client.Object) (*PodCustomDefaulter, client.Client) {
+	fakeScheme := runtime.NewScheme()
+	Expect(corev1.AddToScheme(fakeScheme)).To(Succeed())
+	fakeClient := fake.NewClientBuilder().WithScheme(fakeScheme).WithObjects(initObjs...).Build()
+	return NewPodCustomDefaulter(fakeClient, injector.NewConfigManager(GinkgoT().TempDir())), fakeClient
+}
+
 // getFirstFoundEnvTestBinaryDir locates the first binary in the specified path.
 // ENVTEST-based tests depend on specific binaries, usually located in paths set by
 // controller-runtime. When running tests directly (e.g., via an IDE) without using